@@ -0,0 +1,179 @@
+// Copyright 2025-2026 Stanislav Senotrusov
+//
+// This work is dual-licensed under the Apache License, Version 2.0 and the MIT License.
+// See LICENSE-APACHE and LICENSE-MIT in the top-level directory for details.
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// alternateSeparator separates a source file name from its condition,
+// e.g. ".bashrc##hostname=build01" or ".bashrc##os=linux,user=ci".
+const alternateSeparator = "##"
+
+// splitAlternate splits an alternate source path into the path without the
+// condition and the condition itself. ok is false for paths without a condition.
+func splitAlternate(relPath string) (base, cond string, ok bool) {
+	name := filepath.Base(relPath)
+	idx := strings.Index(name, alternateSeparator)
+	if idx <= 0 {
+		return relPath, "", false
+	}
+	dirLen := len(relPath) - len(name)
+	return relPath[:dirLen+idx], name[idx+len(alternateSeparator):], true
+}
+
+// alternateBase returns the source path without its condition suffix.
+func alternateBase(relPath string) string {
+	base, _, _ := splitAlternate(relPath)
+	return base
+}
+
+// conditionWeights ranks condition keys by specificity. Each weight exceeds the
+// sum of all lower ones, so a hostname match beats any combination of the others.
+var conditionWeights = map[string]int{
+	"hostname": 8,
+	"user":     4,
+	"os":       2,
+	"arch":     1,
+}
+
+// matchCondition evaluates a condition against the host facts.
+// A condition is a comma-separated list of key=value pairs that must all hold.
+// It returns a score that ranks more specific alternates higher.
+func matchCondition(cond string, host hostFacts) (bool, int, error) {
+	if cond == "" {
+		return false, 0, fmt.Errorf("empty condition")
+	}
+
+	matched := true
+	score := 0
+	for _, pair := range strings.Split(cond, ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || value == "" {
+			return false, 0, fmt.Errorf("condition %q must have the form key=value", pair)
+		}
+
+		switch key {
+		case "hostname":
+			// Host names are case-insensitive
+			matched = matched && strings.EqualFold(value, host.Hostname)
+		case "user":
+			matched = matched && value == host.Username
+		case "os":
+			matched = matched && value == host.OS
+		case "arch":
+			matched = matched && value == host.Arch
+		default:
+			return false, 0, fmt.Errorf("unknown condition key %q (expected hostname, user, os or arch)", key)
+		}
+		score += conditionWeights[key]
+	}
+	return matched, score, nil
+}
+
+// resolveAlternates selects, for every file of a source directory that has
+// alternates, the one to sync on this host. Among the alternates whose
+// condition matches, the most specific one wins (see conditionWeights); a file
+// without a condition is the fallback when no alternate matches. The selection is stored in
+// s.alternates, keyed by the path without the condition.
+func (s *syncer) resolveAlternates(absDir, relDir string) error {
+	entries, err := os.ReadDir(absDir)
+	if err != nil {
+		return err
+	}
+
+	groups := make(map[string][]string)
+	hasAlternates := false
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		relPath := filepath.Join(relDir, e.Name())
+		if s.ignore.ignored(relPath, false) {
+			continue
+		}
+		base, _, ok := splitAlternate(relPath)
+		groups[base] = append(groups[base], relPath)
+		hasAlternates = hasAlternates || ok
+	}
+	if !hasAlternates {
+		return nil
+	}
+
+	host, err := s.hostFacts()
+	if err != nil {
+		return err
+	}
+
+	bases := make([]string, 0, len(groups))
+	for base := range groups {
+		bases = append(bases, base)
+	}
+	sort.Strings(bases)
+
+	for _, base := range bases {
+		candidates := groups[base]
+		if len(candidates) == 1 && candidates[0] == base {
+			continue // A plain file without alternates
+		}
+		s.alternates[base] = s.selectAlternate(base, candidates, host)
+	}
+	return nil
+}
+
+// selectAlternate returns the best matching candidate, or "" if none applies.
+func (s *syncer) selectAlternate(base string, candidates []string, host hostFacts) string {
+	var best []string
+	bestScore := -1
+
+	for _, relPath := range candidates {
+		score := 0
+		if _, cond, ok := splitAlternate(relPath); ok {
+			matched, n, err := matchCondition(cond, host)
+			if err != nil {
+				logger.Error("Invalid alternate condition", "path", relPath, "err", err)
+				s.keep(relPath)
+				s.fail(relPath)
+				continue
+			}
+			if !matched {
+				continue
+			}
+			score = n
+		}
+
+		switch {
+		case score > bestScore:
+			best, bestScore = []string{relPath}, score
+		case score == bestScore:
+			best = append(best, relPath)
+		}
+	}
+
+	if len(best) > 1 {
+		// Equally specific alternates would make the result depend on file
+		// names, so none is synced and the destination is left as it is.
+		logger.Error("Ambiguous alternates: several match equally well", "path", base, "candidates", strings.Join(best, ","))
+		for _, relPath := range candidates {
+			s.keep(relPath)
+		}
+		for _, relPath := range best {
+			s.fail(relPath)
+		}
+		return ""
+	}
+	if len(best) == 0 {
+		logger.Debug("No alternate matches this host", "path", base)
+		return ""
+	}
+	return best[0]
+}
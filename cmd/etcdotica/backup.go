@@ -0,0 +1,375 @@
+// Copyright 2025-2026 Stanislav Senotrusov
+//
+// This work is dual-licensed under the Apache License, Version 2.0 and the MIT License.
+// See LICENSE-APACHE and LICENSE-MIT in the top-level directory for details.
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupTimeLayout names backup versions. It is UTC and sorts chronologically.
+const backupTimeLayout = "20060102T150405.000000000Z"
+
+// defaultBackupDir returns the default backup store: /var/backups/etcdotica
+// for root, and $XDG_STATE_HOME/etcdotica/backups (~/.local/state by default)
+// for other users.
+func defaultBackupDir() (string, error) {
+	currentUser, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	if currentUser.Uid == "0" {
+		return "/var/backups/etcdotica", nil
+	}
+	return filepath.Join(xdgStateHome(currentUser), "etcdotica", "backups"), nil
+}
+
+// xdgStateHome returns $XDG_STATE_HOME, or ~/.local/state if it is not set.
+func xdgStateHome(u *user.User) string {
+	if stateHome := os.Getenv("XDG_STATE_HOME"); stateHome != "" {
+		return stateHome
+	}
+	return filepath.Join(u.HomeDir, ".local", "state")
+}
+
+// resolveBackupDir returns the absolute backup store to use, or "" if backups are disabled.
+func resolveBackupDir(dir string, disabled bool) string {
+	if disabled {
+		return ""
+	}
+	if dir == "" {
+		var err error
+		if dir, err = defaultBackupDir(); err != nil {
+			logger.Error("Error determining default backup directory", "err", err)
+			os.Exit(1)
+		}
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		logger.Error("Error resolving backup directory", "err", err)
+		os.Exit(1)
+	}
+	return abs
+}
+
+// pathWithin reports whether path is dir or lies inside it.
+func pathWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// backupVersionsDir returns the directory holding the versions of a
+// destination file. The store mirrors absolute paths, e.g. the versions of
+// /etc/fstab are kept in <store>/etc/fstab/<timestamp>.
+func backupVersionsDir(store, path string) string {
+	vol := filepath.VolumeName(path)
+	rest := path[len(vol):]
+	// Keep drive letters apart on Windows ("C:" becomes "C")
+	return filepath.Join(store, strings.TrimSuffix(vol, ":"), rest)
+}
+
+// backupFile copies the current content of path into the backup store,
+// keeping its permissions and modification time, and its owner when running
+// as root, so that a restore can bring it back. Missing and non-regular
+// files are not backed up. It returns the path of the new backup, if any.
+func backupFile(store, path string) (string, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", nil
+	}
+
+	dir := backupVersionsDir(store, path)
+	// Backups may hold copies of private files, so the store is private too
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	s, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer s.Close()
+	if err := lockFile(s.Fd(), false); err != nil {
+		return "", fmt.Errorf("locking file: %v", err)
+	}
+
+	name := filepath.Join(dir, time.Now().UTC().Format(backupTimeLayout))
+	d, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(d, s); err != nil {
+		d.Close()
+		os.Remove(name)
+		return "", err
+	}
+	if err := d.Close(); err != nil {
+		os.Remove(name)
+		return "", err
+	}
+
+	if err := os.Chmod(name, info.Mode().Perm()); err != nil {
+		return "", err
+	}
+	if ownershipSupported() {
+		owner, err := fileOwnerOf(path)
+		if err != nil {
+			return "", err
+		}
+		if err := os.Lchown(name, owner.uid, owner.gid); err != nil {
+			return "", err
+		}
+	}
+	if err := os.Chtimes(name, info.ModTime(), info.ModTime()); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// backup saves a destination file before its content is replaced or removed.
+// Files whose content already matches the source are not backed up.
+func (s *syncer) backup(dstPath string, src *sourceFile) error {
+	if s.cfg.BackupDir == "" {
+		return nil
+	}
+
+	if src != nil {
+		dstInfo, err := os.Lstat(dstPath)
+		if err != nil || !dstInfo.Mode().IsRegular() {
+			return nil // Nothing to lose: missing, or a symlink that is replaced, not written through
+		}
+		if same, err := src.equalTo(dstPath, dstInfo.Size()); err == nil && same {
+			return nil
+		}
+	}
+
+	name, err := backupFile(s.cfg.BackupDir, dstPath)
+	if err != nil {
+		return fmt.Errorf("backing up %s: %v", dstPath, err)
+	}
+	if name != "" {
+		logger.Info("Saved backup of destination file", "path", dstPath, "backup", name)
+		if err := pruneBackups(s.cfg.BackupDir, dstPath, s.cfg.BackupKeep); err != nil {
+			logger.Warn("Failed to remove old backups", "path", dstPath, "err", err)
+		}
+	}
+	return nil
+}
+
+// pruneBackups removes the oldest versions of path beyond the newest keep.
+// A keep of 0 keeps all versions.
+func pruneBackups(store, path string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	versions, err := listBackups(store, path)
+	if err != nil {
+		return err
+	}
+	for _, v := range versions[:max(len(versions)-keep, 0)] {
+		if err := os.Remove(v.Path); err != nil {
+			return err
+		}
+		logger.Debug("Removed old backup", "path", path, "backup", v.Path)
+	}
+	return nil
+}
+
+// backupOverlaps checks a backup store that lies inside the destination
+// directory. It is an error if the source has a directory at the same place,
+// as its files would be synced into the store; otherwise an explicitly chosen
+// store only gets a warning, since the default one for users other than root
+// is inside their home directory.
+func backupOverlaps(cfg Config) error {
+	if cfg.BackupDir == "" || !pathWithin(cfg.BackupDir, cfg.Dst) {
+		return nil
+	}
+	rel, err := filepath.Rel(cfg.Dst, cfg.BackupDir)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(cfg.Src, rel)); err == nil {
+		return fmt.Errorf("backup directory %s is inside the destination, and the source manages files in it", cfg.BackupDir)
+	}
+	if def, err := defaultBackupDir(); err != nil || def != cfg.BackupDir {
+		logger.Warn("Backup directory is inside the destination directory", "backup-dir", cfg.BackupDir, "dst", cfg.Dst)
+	}
+	return nil
+}
+
+// backupVersion is a stored copy of a destination file.
+type backupVersion struct {
+	Path string
+	Time time.Time
+	Info os.FileInfo
+}
+
+// listBackups returns the stored versions of path, oldest first.
+func listBackups(store, path string) ([]backupVersion, error) {
+	dir := backupVersionsDir(store, path)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var versions []backupVersion
+	for _, e := range entries {
+		t, err := time.Parse(backupTimeLayout, e.Name())
+		if err != nil || !e.Type().IsRegular() {
+			continue // Versions of files inside a same-named directory, or foreign files
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, backupVersion{Path: filepath.Join(dir, e.Name()), Time: t, Info: info})
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Time.Before(versions[j].Time) })
+	return versions, nil
+}
+
+// parseRestoreTime parses the -at argument of the restore command.
+// Times without a zone are taken as local time.
+func parseRestoreTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(backupTimeLayout, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time %q (use e.g. 2006-01-02 15:04:05 or RFC 3339)", s)
+}
+
+// runRestore implements "etcdotica restore PATH [-at TIME]". It copies the
+// latest backup of PATH taken at or before TIME (default: the latest one)
+// back into place, and exits. The current content is backed up first, so a
+// restore can itself be undone. A file that still exists keeps its owner;
+// otherwise the owner recorded with the backup is used.
+func runRestore(args []string) {
+	defaultLogLevel := "info"
+	if env := os.Getenv("EDTC_LOG_LEVEL"); env != "" {
+		defaultLogLevel = env
+	}
+
+	atFlag := flag.String("at", "", "Restore the latest backup taken at or before this time\n(e.g. \"2006-01-02 15:04:05\", local time, or RFC 3339).")
+	backupDirFlag := flag.String("backup-dir", "", "Backup directory (default: /var/backups/etcdotica if root,\notherwise $XDG_STATE_HOME/etcdotica/backups).")
+	backupKeepFlag := flag.Int("backup-keep", 10, "Number of backups kept per file; older ones are removed after the\nrestore (0 keeps all).")
+	listFlag := flag.Bool("list", false, "List the available backups of PATH instead of restoring.")
+	logFormat := flag.String("log-format", "human", "Log format: human, text or json")
+	logLevel := flag.String("log-level", defaultLogLevel, "Log level: debug, info, warn, error")
+
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage: etcdotica restore PATH [options]\n\nOptions:\n")
+		flag.PrintDefaults()
+	}
+
+	// Options may follow the path, as in "restore /etc/fstab -at 2026-01-02"
+	_ = flag.CommandLine.Parse(args)
+	rest := flag.Args()
+	if len(rest) > 0 {
+		_ = flag.CommandLine.Parse(rest[1:])
+	}
+
+	setupLogger(*logFormat, *logLevel)
+
+	if len(rest) == 0 || flag.NArg() > 0 {
+		flag.Usage()
+		logger.Error("Error: restore takes exactly one PATH argument")
+		os.Exit(1)
+	}
+
+	path, err := filepath.Abs(rest[0])
+	if err != nil {
+		logger.Error("Error resolving path", "err", err)
+		os.Exit(1)
+	}
+	store := resolveBackupDir(*backupDirFlag, false)
+
+	versions, err := listBackups(store, path)
+	if err != nil {
+		logger.Error("Error reading backups", "path", path, "err", err)
+		os.Exit(1)
+	}
+
+	if *listFlag {
+		for _, v := range versions {
+			fmt.Printf("%s  %04o  %8d  %s\n", v.Time.Local().Format(time.RFC3339), v.Info.Mode().Perm(), v.Info.Size(), v.Path)
+		}
+		os.Exit(0)
+	}
+
+	if *atFlag != "" {
+		at, err := parseRestoreTime(*atFlag)
+		if err != nil {
+			logger.Error("Error parsing -at", "err", err)
+			os.Exit(1)
+		}
+		for len(versions) > 0 && versions[len(versions)-1].Time.After(at) {
+			versions = versions[:len(versions)-1]
+		}
+	}
+	if len(versions) == 0 {
+		logger.Error("Error: no backup found", "path", path, "backup-dir", store)
+		os.Exit(1)
+	}
+	v := versions[len(versions)-1]
+
+	owner := noOwner
+	if ownershipSupported() {
+		if owner, err = fileOwnerOf(path); os.IsNotExist(err) {
+			owner, err = fileOwnerOf(v.Path)
+		}
+		if err != nil {
+			logger.Error("Error reading owner", "path", path, "err", err)
+			os.Exit(1)
+		}
+	}
+
+	if _, err := backupFile(store, path); err != nil {
+		logger.Error("Error backing up current content", "path", path, "err", err)
+		os.Exit(1)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		logger.Error("Error creating parent directory", "path", path, "err", err)
+		os.Exit(1)
+	}
+	if err := syncFile(v.Path, path, v.Info, v.Info.Mode().Perm(), owner); err != nil {
+		logger.Error("Error restoring backup", "path", path, "backup", v.Path, "err", err)
+		os.Exit(1)
+	}
+
+	// Pruned only now, as the restored version may be among the oldest
+	if err := pruneBackups(store, path, *backupKeepFlag); err != nil {
+		logger.Warn("Failed to remove old backups", "path", path, "err", err)
+	}
+
+	logger.Info("Restored backup", "path", path, "taken", v.Time.Local().Format(time.RFC3339))
+	os.Exit(0)
+}
@@ -0,0 +1,192 @@
+// Copyright 2025-2026 Stanislav Senotrusov
+//
+// This work is dual-licensed under the Apache License, Version 2.0 and the MIT License.
+// See LICENSE-APACHE and LICENSE-MIT in the top-level directory for details.
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// commentStyle is the comment syntax of section markers in a target file,
+// e.g. "# BEGIN name" or "<!-- BEGIN name -->".
+type commentStyle struct {
+	Name  string
+	open  string
+	close string // Empty for line comments
+}
+
+// commentStyles are the supported marker styles. The first one is the default.
+var commentStyles = []commentStyle{
+	{Name: "hash", open: "#"},
+	{Name: "slash", open: "//"},
+	{Name: "semicolon", open: ";"},
+	{Name: "dash", open: "--"},
+	{Name: "quote", open: `"`},
+	{Name: "xml", open: "<!--", close: "-->"},
+}
+
+// extensionStyles selects the marker style by the extension of the target
+// file. Files without a match use the hash style.
+var extensionStyles = map[string]string{
+	".vim":    "quote",
+	".vimrc":  "quote",
+	".gvimrc": "quote",
+	".ini":    "semicolon",
+	".sql":    "dash",
+	".lua":    "dash",
+	".hs":     "dash",
+	".xml":    "xml",
+	".html":   "xml",
+	".htm":    "xml",
+	".xhtml":  "xml",
+	".svg":    "xml",
+	".plist":  "xml",
+	".md":     "xml",
+	".c":      "slash",
+	".h":      "slash",
+	".cc":     "slash",
+	".cpp":    "slash",
+	".hpp":    "slash",
+	".go":     "slash",
+	".rs":     "slash",
+	".java":   "slash",
+	".js":     "slash",
+	".mjs":    "slash",
+	".ts":     "slash",
+	".jsonc":  "slash",
+	".json5":  "slash",
+	".kdl":    "slash",
+}
+
+// commentStyleNames returns the names of the supported styles, separated by sep.
+func commentStyleNames(sep string) string {
+	names := make([]string, len(commentStyles))
+	for i, c := range commentStyles {
+		names[i] = c.Name
+	}
+	return strings.Join(names, sep)
+}
+
+// lookupCommentStyle returns the style with the given name.
+func lookupCommentStyle(name string) (commentStyle, error) {
+	for _, c := range commentStyles {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return commentStyle{}, fmt.Errorf("unknown comment style %q (expected %s)", name, commentStyleNames(", "))
+}
+
+// marker formats a BEGIN or END marker line.
+func (c commentStyle) marker(keyword, name string) string {
+	if c.close == "" {
+		return c.open + " " + keyword + " " + name
+	}
+	return c.open + " " + keyword + " " + name + " " + c.close
+}
+
+// markerChecksumPrefix introduces the checksum of the section body in a BEGIN
+// marker, as in "# BEGIN name sha256=…".
+const markerChecksumPrefix = "sha256="
+
+// parseBegin returns the section name of a BEGIN marker together with the
+// checksum it carries, which is empty for markers without one.
+func (c commentStyle) parseBegin(line string) (name, checksum string, ok bool) {
+	name, ok = c.parseMarker(line, "BEGIN")
+	if !ok {
+		return "", "", false
+	}
+	if i := strings.LastIndex(name, " "+markerChecksumPrefix); i > 0 {
+		return name[:i], name[i+1+len(markerChecksumPrefix):], true
+	}
+	return name, "", true
+}
+
+// parseMarker returns the section name if line is a BEGIN or END marker
+// (as selected by keyword) in this style.
+func (c commentStyle) parseMarker(line, keyword string) (string, bool) {
+	prefix := c.open + " " + keyword + " "
+	suffix := ""
+	if c.close != "" {
+		suffix = " " + c.close
+	}
+	if len(line) <= len(prefix)+len(suffix) || !strings.HasPrefix(line, prefix) || !strings.HasSuffix(line, suffix) {
+		return "", false
+	}
+	return line[len(prefix) : len(line)-len(suffix)], true
+}
+
+// markerStyles returns the styles whose markers are recognized in a target
+// file that uses this style: the style itself and the hash style, which every
+// target file used before the style could be chosen. Sections found with hash
+// markers are rewritten in this style when they are next merged.
+func (c commentStyle) markerStyles() []commentStyle {
+	if c == commentStyles[0] {
+		return []commentStyle{c}
+	}
+	return []commentStyle{c, commentStyles[0]}
+}
+
+// commentRule assigns a marker style to target files matching a pattern.
+type commentRule struct {
+	Pattern string // gitignore-style glob relative to the destination directory
+	Style   commentStyle
+	glob    globPattern
+}
+
+// newCommentRule compiles a comment style rule.
+func newCommentRule(pattern, style string) (commentRule, error) {
+	g, ok, err := compileGlob(pattern)
+	if err != nil {
+		return commentRule{}, fmt.Errorf("comment pattern %q: %v", pattern, err)
+	}
+	if !ok || g.negate {
+		return commentRule{}, fmt.Errorf("invalid comment pattern %q", pattern)
+	}
+	c, err := lookupCommentStyle(style)
+	if err != nil {
+		return commentRule{}, err
+	}
+	return commentRule{Pattern: pattern, Style: c, glob: g}, nil
+}
+
+// parseCommentRuleSpec parses a "PATTERN=STYLE" command line value.
+func parseCommentRuleSpec(spec string) (commentRule, error) {
+	pattern, style, ok := strings.Cut(spec, "=")
+	if !ok {
+		return commentRule{}, fmt.Errorf("comment rule %q must have the form PATTERN=STYLE", spec)
+	}
+	return newCommentRule(strings.TrimSpace(pattern), strings.TrimSpace(style))
+}
+
+// sectionStyle returns the marker style of a section source entry. A style
+// named in the section file name ("config.xml.app-section.xml") wins over
+// the last matching comment rule, which wins over the target's extension.
+func (s *syncer) sectionStyle(relPath string) commentStyle {
+	match := sectionFileRx.FindStringSubmatch(alternateBase(relPath))
+	if match != nil && match[3] != "" {
+		c, _ := lookupCommentStyle(match[3]) // sectionFileRx only accepts known names
+		return c
+	}
+
+	style := commentStyles[0]
+	if match == nil {
+		return style
+	}
+	target := filepath.ToSlash(match[1])
+	if name, ok := extensionStyles[strings.ToLower(filepath.Ext(target))]; ok {
+		style, _ = lookupCommentStyle(name)
+	}
+	for _, r := range s.cfg.CommentRules {
+		if r.glob.match(target, false) || r.glob.matchTree(target) {
+			style = r.Style
+		}
+	}
+	return style
+}
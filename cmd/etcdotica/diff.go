@@ -0,0 +1,433 @@
+// Copyright 2025-2026 Stanislav Senotrusov
+//
+// This work is dual-licensed under the Apache License, Version 2.0 and the MIT License.
+// See LICENSE-APACHE and LICENSE-MIT in the top-level directory for details.
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	// diffContext is the number of unchanged lines shown around each change.
+	diffContext = 3
+
+	// maxDiffEdits bounds the edit distance explored by the Myers algorithm.
+	// Beyond it, the changed region is reported as a single replacement hunk,
+	// which keeps memory usage predictable for unrelated large files.
+	maxDiffEdits = 1000
+
+	// devNull is the conventional label for a missing side of a diff.
+	devNull = "/dev/null"
+)
+
+// editKind describes a single line operation in an edit script.
+type editKind int
+
+const (
+	editEqual editKind = iota
+	editDelete
+	editInsert
+)
+
+// edit is one line of an edit script transforming the old text into the new one.
+type edit struct {
+	kind editKind
+	line string
+}
+
+// writeDiff writes a diff between two versions of a file to w.
+// A zero mode means the mode is unknown or the side does not exist, in which
+// case no mode lines are printed. Nothing is written if the versions are identical.
+func writeDiff(w io.Writer, oldLabel, newLabel string, oldContent, newContent []byte, oldMode, newMode os.FileMode) {
+	if !writeDiffHeader(w, oldLabel, newLabel, oldContent, newContent, oldMode, newMode) {
+		return
+	}
+
+	if bytes.IndexByte(oldContent, 0) >= 0 || bytes.IndexByte(newContent, 0) >= 0 {
+		fmt.Fprintf(w, "Binary files %s and %s differ\n", oldLabel, newLabel)
+		return
+	}
+
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", oldLabel, newLabel)
+	writeHunks(w, oldContent, newContent)
+}
+
+// writeHiddenDiff is like writeDiff, but only reports that the contents
+// differ instead of printing them. It keeps decoded secrets off the terminal.
+func writeHiddenDiff(w io.Writer, oldLabel, newLabel string, oldContent, newContent []byte, oldMode, newMode os.FileMode) {
+	if writeDiffHeader(w, oldLabel, newLabel, oldContent, newContent, oldMode, newMode) {
+		fmt.Fprintf(w, "Filtered files %s and %s differ\n", oldLabel, newLabel)
+	}
+}
+
+// writeDiffHeader writes the header and mode lines of a diff to w, if the
+// versions differ at all. It reports whether the contents differ.
+func writeDiffHeader(w io.Writer, oldLabel, newLabel string, oldContent, newContent []byte, oldMode, newMode os.FileMode) bool {
+	contentChanged := !bytes.Equal(oldContent, newContent)
+	modeChanged := oldMode != 0 && newMode != 0 && oldMode != newMode
+
+	if !contentChanged && !modeChanged {
+		return false
+	}
+
+	fmt.Fprintf(w, "diff %s %s\n", oldLabel, newLabel)
+	if modeChanged {
+		fmt.Fprintf(w, "old mode %04o\nnew mode %04o\n", oldMode, newMode)
+	}
+	return contentChanged
+}
+
+// writeHunks writes the unified diff hunks between two texts.
+func writeHunks(w io.Writer, oldContent, newContent []byte) {
+	a, b := splitLines(oldContent), splitLines(newContent)
+	aNoEOL := len(oldContent) > 0 && oldContent[len(oldContent)-1] != '\n'
+	bNoEOL := len(newContent) > 0 && newContent[len(newContent)-1] != '\n'
+
+	// A last line without a newline differs from the same line with one. It
+	// is compared with a newline appended, which no split line contains.
+	if aNoEOL != bNoEOL {
+		if aNoEOL {
+			a[len(a)-1] += "\n"
+		} else {
+			b[len(b)-1] += "\n"
+		}
+	}
+
+	edits := diffLines(a, b)
+
+	// Precompute the old and new line offsets preceding each edit.
+	aPos := make([]int, len(edits)+1)
+	bPos := make([]int, len(edits)+1)
+	for i, e := range edits {
+		aPos[i+1], bPos[i+1] = aPos[i], bPos[i]
+		if e.kind != editInsert {
+			aPos[i+1]++
+		}
+		if e.kind != editDelete {
+			bPos[i+1]++
+		}
+	}
+
+	i := 0
+	for i < len(edits) {
+		// Find the next change
+		for i < len(edits) && edits[i].kind == editEqual {
+			i++
+		}
+		if i == len(edits) {
+			break
+		}
+
+		start := max(0, i-diffContext)
+
+		// Extend the hunk while the unchanged gap to the next change is small
+		// enough for the two contexts to overlap.
+		end := i
+		for {
+			for end < len(edits) && edits[end].kind != editEqual {
+				end++
+			}
+			run := end
+			for run < len(edits) && edits[run].kind == editEqual {
+				run++
+			}
+			if run == len(edits) || run-end > 2*diffContext {
+				end = min(end+diffContext, run)
+				break
+			}
+			end = run
+		}
+
+		fmt.Fprintf(w, "@@ -%s +%s @@\n",
+			hunkRange(aPos[start], aPos[end]-aPos[start]),
+			hunkRange(bPos[start], bPos[end]-bPos[start]))
+
+		for j := start; j < end; j++ {
+			e := edits[j]
+			line := strings.TrimSuffix(e.line, "\n")
+			switch e.kind {
+			case editEqual:
+				fmt.Fprintf(w, " %s\n", line)
+			case editDelete:
+				fmt.Fprintf(w, "-%s\n", line)
+			case editInsert:
+				fmt.Fprintf(w, "+%s\n", line)
+			}
+			if (e.kind != editInsert && aNoEOL && aPos[j+1] == len(a)) ||
+				(e.kind != editDelete && bNoEOL && bPos[j+1] == len(b)) {
+				fmt.Fprintln(w, `\ No newline at end of file`)
+			}
+		}
+		i = end
+	}
+}
+
+// hunkRange formats the "start,count" part of a hunk header.
+// By convention, an empty range refers to the line before the change.
+func hunkRange(offset, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", offset)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", offset+1)
+	}
+	return fmt.Sprintf("%d,%d", offset+1, count)
+}
+
+// diffLines computes a line edit script between a and b.
+// Common prefix and suffix are trimmed before running the Myers algorithm.
+func diffLines(a, b []string) []edit {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var edits []edit
+	for _, line := range a[:prefix] {
+		edits = append(edits, edit{editEqual, line})
+	}
+	edits = append(edits, myersDiff(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		edits = append(edits, edit{editEqual, line})
+	}
+	return edits
+}
+
+// myersDiff implements the greedy Myers O(ND) difference algorithm.
+// If the edit distance exceeds maxDiffEdits, it falls back to deleting all of
+// a and inserting all of b.
+func myersDiff(a, b []string) []edit {
+	n, m := len(a), len(b)
+	limit := min(n+m, maxDiffEdits)
+	offset := limit + 1
+
+	v := make([]int, 2*offset+1)
+	var trace [][]int
+
+	for d := 0; d <= limit; d++ {
+		trace = append(trace, append([]int(nil), v...))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // Move down (insertion)
+			} else {
+				x = v[offset+k-1] + 1 // Move right (deletion)
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				return backtrack(a, b, trace, offset)
+			}
+		}
+	}
+
+	// Edit distance too large: report as a full replacement.
+	edits := make([]edit, 0, n+m)
+	for _, line := range a {
+		edits = append(edits, edit{editDelete, line})
+	}
+	for _, line := range b {
+		edits = append(edits, edit{editInsert, line})
+	}
+	return edits
+}
+
+// backtrack walks the recorded Myers trace from the end to build the edit script.
+func backtrack(a, b []string, trace [][]int, offset int) []edit {
+	var reversed []edit
+	x, y := len(a), len(b)
+
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			reversed = append(reversed, edit{editEqual, a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				reversed = append(reversed, edit{editInsert, b[y-1]})
+			} else {
+				reversed = append(reversed, edit{editDelete, a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	edits := make([]edit, len(reversed))
+	for i, e := range reversed {
+		edits[len(reversed)-1-i] = e
+	}
+	return edits
+}
+
+// diffFile prints the difference between the destination and the source file
+// that syncFile is about to copy over it.
+func (s *syncer) diffFile(src sourceFile, dstPath string, perm os.FileMode) {
+	newContent, err := src.read()
+	if err != nil {
+		logger.Warn("Failed to read source for diff", "path", src.path, "err", err)
+		return
+	}
+
+	oldLabel := dstPath
+	oldContent, oldMode, err := readForDiff(dstPath)
+	if err != nil {
+		logger.Warn("Failed to read destination for diff", "path", dstPath, "err", err)
+		return
+	}
+	if oldMode == 0 {
+		oldLabel = devNull
+	}
+
+	s.writeSourceDiff(src, oldLabel, dstPath, oldContent, newContent, oldMode, perm)
+}
+
+// diffCollect prints the difference a collection would apply to the source
+// file. Filtered files are compared in their decoded form.
+func (s *syncer) diffCollect(src sourceFile, dstPath string) {
+	srcPath := src.path
+	oldContent, err := src.read()
+	if err != nil {
+		logger.Warn("Failed to read source for diff", "path", srcPath, "err", err)
+		return
+	}
+	newContent, err := os.ReadFile(dstPath)
+	if err != nil {
+		logger.Warn("Failed to read destination for diff", "path", dstPath, "err", err)
+		return
+	}
+	s.writeSourceDiff(src, srcPath, srcPath, oldContent, newContent, 0, 0)
+}
+
+// writeSourceDiff prints a diff involving the content of a source file. The
+// decoded content of filtered files is only printed with -diff-filtered.
+func (s *syncer) writeSourceDiff(src sourceFile, oldLabel, newLabel string, oldContent, newContent []byte, oldMode, newMode os.FileMode) {
+	if src.filter != nil && !s.cfg.DiffFiltered {
+		writeHiddenDiff(os.Stdout, oldLabel, newLabel, oldContent, newContent, oldMode, newMode)
+		return
+	}
+	writeDiff(os.Stdout, oldLabel, newLabel, oldContent, newContent, oldMode, newMode)
+}
+
+// diffSection prints the before/after content of a target file for a section merge.
+func (s *syncer) diffSection(srcPath, dstPath, sectionName string, style commentStyle, place sectionPlacement, srcInfo os.FileInfo) {
+	oldContent, newContent, err := previewMergeSection(srcPath, dstPath, sectionName, style, place, s.cfg.SectionChecksums)
+	if err != nil {
+		logger.Warn("Failed to compute section diff", "section", sectionName, "target", dstPath, "err", err)
+		return
+	}
+
+	oldLabel := dstPath
+	oldMode := os.FileMode(0)
+	if info, err := os.Stat(dstPath); err == nil {
+		oldMode = info.Mode().Perm()
+	} else {
+		oldLabel = devNull
+	}
+	newMode := calculatePerms(srcInfo.Mode(), s.cfg.ProcessUmask, s.cfg.Everyone)
+
+	writeDiff(os.Stdout, oldLabel, dstPath, oldContent, newContent, oldMode, newMode)
+}
+
+// diffRemoveSection prints the before/after content of a target file for a section removal.
+func (s *syncer) diffRemoveSection(dstPath, sectionName string, style commentStyle) {
+	oldContent, newContent, err := previewRemoveSection(dstPath, sectionName, style)
+	if err != nil {
+		logger.Warn("Failed to compute section diff", "section", sectionName, "target", dstPath, "err", err)
+		return
+	}
+	writeDiff(os.Stdout, dstPath, dstPath, oldContent, newContent, 0, 0)
+}
+
+// diffFragment prints the before/after content of a target file for a fragment merge.
+func (s *syncer) diffFragment(format *fragmentFormat, srcPath, dstPath string, keys []mergedKey, srcInfo os.FileInfo) {
+	oldContent, newContent, err := previewMergeFragment(format, srcPath, dstPath, keys)
+	if err != nil {
+		logger.Warn("Failed to compute merge diff", "fragment", srcPath, "target", dstPath, "err", err)
+		return
+	}
+
+	oldLabel := dstPath
+	oldMode := os.FileMode(0)
+	if info, err := os.Stat(dstPath); err == nil {
+		oldMode = info.Mode().Perm()
+	} else {
+		oldLabel = devNull
+	}
+	newMode := calculatePerms(srcInfo.Mode(), s.cfg.ProcessUmask, s.cfg.Everyone)
+
+	writeDiff(os.Stdout, oldLabel, dstPath, oldContent, newContent, oldMode, newMode)
+}
+
+// diffRemoveFragment prints the before/after content of a target file for the removal of fragment keys.
+func (s *syncer) diffRemoveFragment(format *fragmentFormat, dstPath string, keys []mergedKey) {
+	oldContent, newContent, err := previewRemoveFragment(format, dstPath, keys)
+	if err != nil {
+		logger.Warn("Failed to compute fragment removal diff", "target", dstPath, "err", err)
+		return
+	}
+	writeDiff(os.Stdout, dstPath, dstPath, oldContent, newContent, 0, 0)
+}
+
+// diffPrune prints the removal of an orphaned destination file.
+func (s *syncer) diffPrune(dstPath string) {
+	oldContent, oldMode, err := readForDiff(dstPath)
+	if err != nil {
+		logger.Warn("Failed to read destination for diff", "path", dstPath, "err", err)
+		return
+	}
+	if oldMode == 0 {
+		return // Already gone
+	}
+	writeDiff(os.Stdout, dstPath, devNull, oldContent, nil, 0, 0)
+}
+
+// readForDiff reads a regular file and its permissions for diff output.
+// A missing file, or a non-regular one such as a symlink, is reported with a zero mode.
+func readForDiff(path string) ([]byte, os.FileMode, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, 0, nil
+		}
+		return nil, 0, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, 0, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	return content, info.Mode().Perm(), nil
+}
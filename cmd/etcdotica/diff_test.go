@@ -0,0 +1,202 @@
+// Copyright 2025-2026 Stanislav Senotrusov
+//
+// This work is dual-licensed under the Apache License, Version 2.0 and the MIT License.
+// See LICENSE-APACHE and LICENSE-MIT in the top-level directory for details.
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// numberedLines returns the lines "1" to "n".
+func numberedLines(n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprint(i + 1)
+	}
+	return lines
+}
+
+func TestWriteHunks(t *testing.T) {
+	ten := numberedLines(10)
+	replaced := func(lines []string, at int, with string) []string {
+		out := append([]string{}, lines...)
+		out[at] = with
+		return out
+	}
+
+	tests := []struct {
+		name     string
+		old, new string
+		want     string
+	}{
+		{
+			name: "insert into empty",
+			old:  "",
+			new:  "a\nb\n",
+			want: "@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "delete everything",
+			old:  "a\n",
+			new:  "",
+			want: "@@ -1 +0,0 @@\n-a\n",
+		},
+		{
+			name: "change with context",
+			old:  string(joinLines(ten)),
+			new:  string(joinLines(replaced(ten, 4, "five"))),
+			want: "@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name: "nearby changes share a hunk",
+			old:  string(joinLines(ten)),
+			new:  string(joinLines(replaced(replaced(ten, 1, "two"), 8, "nine"))),
+			want: "@@ -1,10 +1,10 @@\n 1\n-2\n+two\n 3\n 4\n 5\n 6\n 7\n 8\n-9\n+nine\n 10\n",
+		},
+		{
+			name: "distant changes get separate hunks",
+			old:  string(joinLines(numberedLines(20))),
+			new:  string(joinLines(replaced(replaced(numberedLines(20), 0, "one"), 19, "twenty"))),
+			want: "@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -17,4 +17,4 @@\n 17\n 18\n 19\n-20\n+twenty\n",
+		},
+		{
+			name: "pure insertion",
+			old:  "a\nb\n",
+			new:  "a\nx\nb\n",
+			want: "@@ -1,2 +1,3 @@\n a\n+x\n b\n",
+		},
+		{
+			name: "missing newline at end",
+			old:  "a\nb",
+			new:  "a\nb\n",
+			want: "@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+		{
+			name: "newline removed at end",
+			old:  "a\n",
+			new:  "a",
+			want: "@@ -1 +1 @@\n-a\n+a\n\\ No newline at end of file\n",
+		},
+		{
+			name: "line appended after missing newline",
+			old:  "a",
+			new:  "a\nb\n",
+			want: "@@ -1 +1,2 @@\n-a\n\\ No newline at end of file\n+a\n+b\n",
+		},
+		{
+			name: "both without newline at end",
+			old:  "a\nb",
+			new:  "a\nc",
+			want: "@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			writeHunks(&buf, []byte(tt.old), []byte(tt.new))
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteDiff(t *testing.T) {
+	tests := []struct {
+		name             string
+		old, new         string
+		oldMode, newMode os.FileMode
+		want             string
+	}{
+		{
+			name: "identical",
+			old:  "a\n", new: "a\n",
+			oldMode: 0644, newMode: 0644,
+			want: "",
+		},
+		{
+			name: "mode only",
+			old:  "a\n", new: "a\n",
+			oldMode: 0644, newMode: 0600,
+			want: "diff x y\nold mode 0644\nnew mode 0600\n",
+		},
+		{
+			name: "unknown mode is not compared",
+			old:  "a\n", new: "a\n",
+			oldMode: 0, newMode: 0600,
+			want: "",
+		},
+		{
+			name: "binary",
+			old:  "a\x00", new: "b\x00",
+			want: "diff x y\nBinary files x and y differ\n",
+		},
+		{
+			name: "content",
+			old:  "a\n", new: "b\n",
+			want: "diff x y\n--- x\n+++ y\n@@ -1 +1 @@\n-a\n+b\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			writeDiff(&buf, "x", "y", []byte(tt.old), []byte(tt.new), tt.oldMode, tt.newMode)
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffLinesFallsBackToReplacement(t *testing.T) {
+	var a, b []string
+	for i := range maxDiffEdits {
+		a = append(a, fmt.Sprint("a", i))
+		b = append(b, fmt.Sprint("b", i))
+	}
+	a = append([]string{"same"}, a...)
+	b = append([]string{"same"}, b...)
+
+	edits := diffLines(a, b)
+	if len(edits) != 1+2*maxDiffEdits {
+		t.Fatalf("got %d edits, want %d", len(edits), 1+2*maxDiffEdits)
+	}
+	if edits[0] != (edit{editEqual, "same"}) {
+		t.Errorf("common prefix not kept: %+v", edits[0])
+	}
+	for i, e := range edits[1 : 1+maxDiffEdits] {
+		if e.kind != editDelete || !strings.HasPrefix(e.line, "a") {
+			t.Fatalf("edit %d: got %+v, want a deletion", i+1, e)
+		}
+	}
+	for i, e := range edits[1+maxDiffEdits:] {
+		if e.kind != editInsert || !strings.HasPrefix(e.line, "b") {
+			t.Fatalf("edit %d: got %+v, want an insertion", i+1+maxDiffEdits, e)
+		}
+	}
+}
+
+func TestWriteHiddenDiff(t *testing.T) {
+	var buf bytes.Buffer
+	writeHiddenDiff(&buf, "x", "y", []byte("secret\n"), []byte("other\n"), 0644, 0600)
+	want := "diff x y\nold mode 0644\nnew mode 0600\nFiltered files x and y differ\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	buf.Reset()
+	writeHiddenDiff(&buf, "x", "y", []byte("same\n"), []byte("same\n"), 0644, 0644)
+	if buf.Len() != 0 {
+		t.Errorf("identical versions printed:\n%s", buf.String())
+	}
+}
@@ -9,6 +9,8 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
@@ -19,7 +21,7 @@ import (
 // syncFile copies content and forces the specific calculated permissions.
 // It optimizes by checking if content is already identical (size & bytes) to avoid writing.
 // It acquires an exclusive lock on the destination file during the operation.
-func syncFile(src, dst string, info os.FileInfo, perm os.FileMode) error {
+func syncFile(src, dst string, info os.FileInfo, perm os.FileMode, own fileOwner) error {
 	logger.Debug("Syncing file", "src", src, "dst", dst)
 	s, err := os.Open(src)
 	if err != nil {
@@ -32,6 +34,13 @@ func syncFile(src, dst string, info os.FileInfo, perm os.FileMode) error {
 		return fmt.Errorf("locking source file: %v", err)
 	}
 
+	return syncContent(s, dst, info, perm, own)
+}
+
+// syncContent writes the content read from s to dst and applies perm, own and
+// the modification time of info. info.Size() must match the content length.
+// It acquires an exclusive lock on the destination file during the operation.
+func syncContent(s io.ReadSeeker, dst string, info os.FileInfo, perm os.FileMode, own fileOwner) error {
 	// 1. Open destination.
 	// We use O_RDWR|O_CREATE to allow reading for content comparison optimization.
 	// We explicitly AVOID O_TRUNC here to prevent wiping the file before we acquire the lock.
@@ -87,6 +96,14 @@ func syncFile(src, dst string, info os.FileInfo, perm os.FileMode) error {
 		return err
 	}
 
+	// 5a. Sync Ownership (only when managed, i.e. running as root)
+	if own.set() {
+		if err := d.Chown(own.uid, own.gid); err != nil {
+			d.Close()
+			return err
+		}
+	}
+
 	// 6. Close (Releases Lock)
 	if err := d.Close(); err != nil {
 		return err
@@ -105,7 +122,7 @@ func syncFile(src, dst string, info os.FileInfo, perm os.FileMode) error {
 // verifyContent checks if the file on disk matches the source file byte-by-byte.
 // If content differs (modification between Close and Chtimes), it touches the file
 // to force a resync on the next run.
-func verifyContent(src *os.File, dstPath string) error {
+func verifyContent(src io.ReadSeeker, dstPath string) error {
 	// Reset source cursor
 	if _, err := src.Seek(0, 0); err != nil {
 		return fmt.Errorf("seeking source file for verification: %v", err)
@@ -173,6 +190,88 @@ func readLines(path string) ([]string, error) {
 	return splitLines(b), nil
 }
 
+// fileDigest returns the digest of a file's content, in the form recorded in the state.
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sourceFile describes what is synced for a source entry.
+type sourceFile struct {
+	path    string      // Path of the file in the source tree
+	info    os.FileInfo // Metadata used for comparisons; reflects the rendered output for templates
+	content []byte      // Rendered or decoded content; nil when the file is copied verbatim
+	tmpl    bool        // Whether content was rendered from a template
+	filter  *contentFilter
+}
+
+// collectable reports whether destination changes can be written back to the source.
+// Rendered output cannot be turned back into its template, and decoded
+// content only with an encode command.
+func (f sourceFile) collectable() bool {
+	return !f.tmpl && (f.filter == nil || f.filter.Encode != "")
+}
+
+// read returns the content that is synced to the destination.
+func (f sourceFile) read() ([]byte, error) {
+	if f.content != nil {
+		return f.content, nil
+	}
+	return os.ReadFile(f.path)
+}
+
+// equalTo reports whether the destination file has exactly the synced content.
+func (f sourceFile) equalTo(dstPath string, dstSize int64) (bool, error) {
+	if f.content == nil {
+		return filesEqual(f.path, dstPath, f.info.Size(), dstSize)
+	}
+	if int64(len(f.content)) != dstSize {
+		return false, nil
+	}
+	d, err := os.Open(dstPath)
+	if err != nil {
+		return false, err
+	}
+	defer d.Close()
+	return contentsEqual(bytes.NewReader(f.content), d)
+}
+
+// syncSource writes a source entry to dst, copying the file or its rendered or decoded content.
+func syncSource(src sourceFile, dst string, perm os.FileMode, own fileOwner) error {
+	if src.content == nil {
+		return syncFile(src.path, dst, src.info, perm, own)
+	}
+	logger.Debug("Syncing rendered file", "src", src.path, "dst", dst)
+	return syncContent(bytes.NewReader(src.content), dst, src.info, perm, own)
+}
+
+// readLockedShared reads a file under a shared lock.
+// A missing file is reported as empty content.
+func readLockedShared(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	if err := lockFile(f.Fd(), false); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(f)
+}
+
 // writeContent rewrites the file from the beginning.
 func writeContent(f *os.File, data []byte) error {
 	if err := f.Truncate(0); err != nil {
@@ -195,3 +294,13 @@ func splitLines(b []byte) []string {
 	}
 	return lines
 }
+
+// joinLines is the inverse of splitLines: it joins lines with a newline after each of them.
+func joinLines(lines []string) []byte {
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,145 @@
+// Copyright 2025-2026 Stanislav Senotrusov
+//
+// This work is dual-licensed under the Apache License, Version 2.0 and the MIT License.
+// See LICENSE-APACHE and LICENSE-MIT in the top-level directory for details.
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// contentFilter converts source files matching Pattern on their way to and
+// from the destination, like git's smudge and clean filters. Decode turns the
+// content kept in the source (e.g. encrypted) into the destination content;
+// Encode turns destination content back into source content when collecting.
+type contentFilter struct {
+	Pattern string // gitignore-style glob relative to the source directory
+	Decode  string // Shell command line reading source content on stdin
+	Encode  string // Shell command line reading destination content on stdin; optional
+	glob    globPattern
+}
+
+// newContentFilter compiles a filter definition.
+func newContentFilter(pattern, decode, encode string) (contentFilter, error) {
+	if strings.TrimSpace(decode) == "" {
+		return contentFilter{}, fmt.Errorf("filter for %q has an empty decode command", pattern)
+	}
+	g, ok, err := compileGlob(pattern)
+	if err != nil {
+		return contentFilter{}, fmt.Errorf("filter pattern %q: %v", pattern, err)
+	}
+	if !ok || g.negate {
+		return contentFilter{}, fmt.Errorf("invalid filter pattern %q", pattern)
+	}
+	return contentFilter{Pattern: pattern, Decode: decode, Encode: encode, glob: g}, nil
+}
+
+// parseFilterSpecs combines "PATTERN=COMMAND" values of -decode and -encode
+// into filters. Every encode command needs a decode command for the same pattern.
+func parseFilterSpecs(decodeSpecs, encodeSpecs []string) ([]contentFilter, error) {
+	var filters []contentFilter
+	for _, spec := range decodeSpecs {
+		pattern, command, ok := strings.Cut(spec, "=")
+		if !ok {
+			return nil, fmt.Errorf("decode filter %q must have the form PATTERN=COMMAND", spec)
+		}
+		f, err := newContentFilter(strings.TrimSpace(pattern), command, "")
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+
+	for _, spec := range encodeSpecs {
+		pattern, command, ok := strings.Cut(spec, "=")
+		if !ok {
+			return nil, fmt.Errorf("encode filter %q must have the form PATTERN=COMMAND", spec)
+		}
+		pattern = strings.TrimSpace(pattern)
+		found := false
+		for i := range filters {
+			if filters[i].Pattern == pattern {
+				filters[i].Encode = command
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("encode filter for %q has no matching decode filter", pattern)
+		}
+	}
+	return filters, nil
+}
+
+// filterFor returns the filter applying to a source path, or nil if the file
+// is synced as it is. The last matching filter wins. Patterns match the path
+// without its alternate suffix, so "secrets.age##hostname=x" matches "*.age".
+func (s *syncer) filterFor(relPath string) *contentFilter {
+	relPath = filepath.ToSlash(alternateBase(relPath))
+	var found *contentFilter
+	for i := range s.cfg.Filters {
+		if f := &s.cfg.Filters[i]; f.glob.match(relPath, false) || f.glob.matchTree(relPath) {
+			found = f
+		}
+	}
+	return found
+}
+
+// runFilter pipes input through a filter command and returns its output.
+// The command runs in the source directory and finds the source path being
+// converted in the ETCDOTICA_PATH environment variable.
+func (s *syncer) runFilter(command, relPath string, input []byte) ([]byte, error) {
+	var out bytes.Buffer
+	cmd := shellCommand(command)
+	cmd.Dir = s.cfg.Src
+	cmd.Env = append(os.Environ(), "ETCDOTICA_PATH="+filepath.ToSlash(relPath))
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &out
+	// Standard error stays attached, so tools like gpg can report problems or ask for a passphrase
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("filter command %q failed: %v", command, err)
+	}
+	// A non-nil result distinguishes empty output from verbatim copies
+	return append([]byte{}, out.Bytes()...), nil
+}
+
+// decodeSource returns the decoded content of a filtered source file.
+func (s *syncer) decodeSource(srcPath, relPath string, f *contentFilter) ([]byte, error) {
+	raw, err := os.ReadFile(srcPath)
+	if err != nil {
+		return nil, err
+	}
+	content, err := s.runFilter(f.Decode, relPath, raw)
+	if err != nil {
+		return nil, fmt.Errorf("decoding: %v", err)
+	}
+	return content, nil
+}
+
+// collectFiltered encodes the destination content and writes it to the
+// source file, keeping the source file's permissions.
+func (s *syncer) collectFiltered(relPath string, src sourceFile, dstPath string, dstInfo os.FileInfo) error {
+	content, err := readLockedShared(dstPath)
+	if err != nil {
+		return err
+	}
+	encoded, err := s.runFilter(src.filter.Encode, relPath, content)
+	if err != nil {
+		return fmt.Errorf("encoding: %v", err)
+	}
+
+	info := renderedInfo{FileInfo: dstInfo, size: int64(len(encoded)), modTime: dstInfo.ModTime()}
+	if err := syncContent(bytes.NewReader(encoded), src.path, info, src.info.Mode(), noOwner); err != nil {
+		return err
+	}
+	s.metaCache[src.path] = fileMeta{ModTime: dstInfo.ModTime(), Size: info.size, Mode: src.info.Mode()}
+	return nil
+}
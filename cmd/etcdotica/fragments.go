@@ -0,0 +1,278 @@
+// Copyright 2025-2026 Stanislav Senotrusov
+//
+// This work is dual-licensed under the Apache License, Version 2.0 and the MIT License.
+// See LICENSE-APACHE and LICENSE-MIT in the top-level directory for details.
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+)
+
+// fragmentFormat merges source fragments of one kind into structured target
+// files key by key, where markers like those of sections cannot be used.
+type fragmentFormat struct {
+	Name string         // e.g. "json"; used in logs and plans
+	rx   *regexp.Regexp // Group 1: target base path, group 2: fragment name
+
+	// merge sets the fragment's keys in the target content and undoes the keys
+	// of the previous merge that the fragment no longer sets. It returns the new
+	// content, the keys the fragment now contributes, and whether the content changed.
+	merge func(oldContent, fragment []byte, keys []mergedKey) ([]byte, []mergedKey, bool, error)
+
+	// remove undoes the keys of a deleted fragment.
+	remove func(oldContent []byte, keys []mergedKey) ([]byte, bool, error)
+}
+
+// fragmentFormats are the supported merge fragments, selected by file name suffix.
+var fragmentFormats = []fragmentFormat{
+	// e.g. ".config/Code/User/settings.json.editor-json-merge"
+	{Name: "json", rx: regexp.MustCompile(`^(.+)\.([^./]+)-json-merge$`), merge: computeMergedJSON, remove: computeRemovedJSON},
+	// e.g. ".gitconfig.identity-ini-merge"
+	{Name: "ini", rx: regexp.MustCompile(`^(.+)\.([^./]+)-ini-merge$`), merge: computeMergedINI, remove: computeRemovedINI},
+	// e.g. "etc/shells.fish-lines"
+	{Name: "lines", rx: regexp.MustCompile(`^(.+)\.([^./]+)-lines$`), merge: computeMergedLines, remove: computeRemovedLines},
+}
+
+// matchFragment returns the format of a merge fragment together with its
+// target base path and fragment name. relPath must have alternate suffixes removed.
+func matchFragment(relPath string) (*fragmentFormat, string, string, bool) {
+	for i := range fragmentFormats {
+		if match := fragmentFormats[i].rx.FindStringSubmatch(relPath); match != nil {
+			return &fragmentFormats[i], match[1], match[2], true
+		}
+	}
+	return nil, "", "", false
+}
+
+// isFragment reports whether a source path names a merge fragment.
+func isFragment(relPath string) bool {
+	_, _, _, ok := matchFragment(relPath)
+	return ok
+}
+
+// mergeFragment reads the fragment and merges it into the target file, which
+// is created if missing. Like mergeSection, it locks the target and enforces
+// the permissions derived from the fragment. It returns whether the target
+// changed and the keys the fragment contributes.
+func mergeFragment(format *fragmentFormat, srcPath, dstPath string, keys []mergedKey, srcInfo os.FileInfo, umask os.FileMode, everyone bool) (bool, []mergedKey, error) {
+	fragment, err := os.ReadFile(srcPath)
+	if err != nil {
+		return false, nil, err
+	}
+
+	if info, err := os.Stat(dstPath); err == nil && info.IsDir() {
+		return false, nil, fmt.Errorf("conflict: target %s is a directory", dstPath)
+	}
+
+	expectedPerms := calculatePerms(srcInfo.Mode(), umask, everyone)
+
+	f, err := os.OpenFile(dstPath, os.O_RDWR|os.O_CREATE, expectedPerms)
+	if err != nil {
+		return false, nil, err
+	}
+	defer f.Close()
+
+	if err := lockFile(f.Fd(), true); err != nil {
+		return false, nil, err
+	}
+
+	content, err := io.ReadAll(f)
+	if err != nil {
+		return false, nil, err
+	}
+
+	newBytes, contributed, changed, err := format.merge(content, fragment, keys)
+	if err != nil {
+		return false, nil, err
+	}
+
+	if changed {
+		if err := writeContent(f, newBytes); err != nil {
+			return false, nil, err
+		}
+	}
+
+	if err := f.Chmod(expectedPerms); err != nil {
+		logger.Warn("Failed to chmod", "path", dstPath, "err", err)
+	}
+
+	return changed, contributed, nil
+}
+
+// removeFragment undoes the keys contributed by a deleted fragment in the target file.
+func removeFragment(format *fragmentFormat, dstPath string, keys []mergedKey) (bool, error) {
+	f, err := os.OpenFile(dstPath, os.O_RDWR, 0666)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer f.Close()
+
+	if err := lockFile(f.Fd(), true); err != nil {
+		return false, err
+	}
+
+	content, err := io.ReadAll(f)
+	if err != nil {
+		return false, err
+	}
+
+	newBytes, changed, err := format.remove(content, keys)
+	if err != nil || !changed {
+		return false, err
+	}
+	return true, writeContent(f, newBytes)
+}
+
+// previewMergeFragment returns the current target content and the content
+// mergeFragment would produce, without modifying the target file.
+func previewMergeFragment(format *fragmentFormat, srcPath, dstPath string, keys []mergedKey) ([]byte, []byte, error) {
+	fragment, err := os.ReadFile(srcPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if info, err := os.Stat(dstPath); err == nil && info.IsDir() {
+		return nil, nil, fmt.Errorf("conflict: target %s is a directory", dstPath)
+	}
+
+	content, err := readLockedShared(dstPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	newBytes, _, _, err := format.merge(content, fragment, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	return content, newBytes, nil
+}
+
+// previewRemoveFragment returns the current target content and the content
+// removeFragment would produce, without modifying the target file.
+func previewRemoveFragment(format *fragmentFormat, dstPath string, keys []mergedKey) ([]byte, []byte, error) {
+	content, err := readLockedShared(dstPath)
+	if err != nil || content == nil {
+		return content, content, err
+	}
+	newBytes, _, err := format.remove(content, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	return content, newBytes, nil
+}
+
+// processFragment merges a fragment into its target file.
+// Edits in the target are not collected: the merge sets the fragment's keys again.
+func (s *syncer) processFragment(format *fragmentFormat, srcPath, relPath, targetRel, name string, info os.FileInfo) error {
+	targetAbsPath := filepath.Join(s.cfg.Dst, targetRel)
+
+	if err := s.claim(relPath); err != nil {
+		s.keep(relPath)
+		return err
+	}
+
+	// Like a section file, the fragment is processed but not copied
+	s.newState[relPath] = s.oldState[relPath]
+	s.processedFiles[relPath] = true
+
+	// Watch optimization: skip if neither the fragment nor the target has changed
+	srcCached := s.checkCache(srcPath, info)
+	dstCached := s.checkTargetCache(targetAbsPath, format.Name+":"+name)
+	if srcCached && dstCached {
+		return nil
+	}
+
+	keys := s.oldState[relPath].Keys
+	logger.Debug("Processing merge fragment", "format", format.Name, "name", name, "target", targetAbsPath)
+
+	if s.cfg.Diff {
+		s.diffFragment(format, srcPath, targetAbsPath, keys, info)
+	}
+
+	if s.cfg.DryRun {
+		if err := s.planFragment(format, relPath, srcPath, targetAbsPath, name, keys, info); err != nil {
+			logger.Error("Failed to plan merge", "format", format.Name, "fragment", name, "target", targetAbsPath, "err", err)
+			s.fail(relPath)
+		}
+		return nil
+	}
+
+	_, statErr := os.Stat(targetAbsPath)
+	created := os.IsNotExist(statErr)
+
+	didChange, contributed, err := mergeFragment(format, srcPath, targetAbsPath, keys, info, s.cfg.ProcessUmask, s.cfg.Everyone)
+	if err != nil {
+		logger.Error("Failed to merge fragment", "format", format.Name, "fragment", name, "target", targetAbsPath, "err", err)
+		delete(s.metaCache, srcPath)
+		s.fail(relPath)
+		return nil
+	}
+	if created {
+		s.applyOwner(targetAbsPath, false)
+	}
+
+	if didChange {
+		logger.Debug("Fragment merged and content changed", "format", format.Name, "target", targetAbsPath)
+		s.mergedTargets[targetAbsPath] = true
+		s.changed = true
+		s.markApplied(relPath)
+		s.touched(targetAbsPath)
+	}
+	if !slices.EqualFunc(keys, contributed, mergedKey.equal) {
+		s.newState[relPath] = stateEntry{Keys: contributed}
+		s.changed = true
+	}
+	return nil
+}
+
+// pruneFragment undoes the keys contributed by a deleted fragment in its target.
+func (s *syncer) pruneFragment(format *fragmentFormat, relPath, targetPath, name string) {
+	keys := s.oldState[relPath].Keys
+
+	if s.cfg.Diff {
+		s.diffRemoveFragment(format, targetPath, keys)
+	}
+
+	if s.cfg.DryRun {
+		if err := s.planRemoveFragment(format, relPath, targetPath, name, keys); err != nil {
+			logger.Error("Failed to plan fragment removal", "format", format.Name, "fragment", name, "target", targetPath, "err", err)
+			s.fail(relPath)
+		}
+		return
+	}
+
+	chg, err := removeFragment(format, targetPath, keys)
+	switch {
+	case err != nil:
+		logger.Error("Failed to remove fragment keys", "format", format.Name, "fragment", name, "target", targetPath, "err", err)
+		// The keys are only known from the state, so keep them for the next attempt
+		s.newState[relPath] = s.oldState[relPath]
+		s.fail(relPath)
+	case chg:
+		logger.Debug("Removed keys of orphaned fragment", "format", format.Name, "fragment", name, "target", targetPath)
+		s.changed = true
+		s.touched(targetPath)
+	default:
+		logger.Debug("Keys of orphaned fragment already gone; state matches desired", "format", format.Name, "fragment", name, "target", targetPath)
+		s.changed = true
+	}
+}
+
+// equal reports whether two merged keys are identical.
+func (k mergedKey) equal(other mergedKey) bool {
+	if !slices.Equal(k.Path, other.Path) || (k.Prior == nil) != (other.Prior == nil) {
+		return false
+	}
+	return k.Prior == nil || *k.Prior == *other.Prior
+}
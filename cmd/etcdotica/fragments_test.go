@@ -0,0 +1,259 @@
+// Copyright 2025-2026 Stanislav Senotrusov
+//
+// This work is dual-licensed under the Apache License, Version 2.0 and the MIT License.
+// See LICENSE-APACHE and LICENSE-MIT in the top-level directory for details.
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// lookupFragmentFormat returns the merge fragment format with the given name.
+func lookupFragmentFormat(t *testing.T, name string) *fragmentFormat {
+	t.Helper()
+	for i := range fragmentFormats {
+		if fragmentFormats[i].Name == name {
+			return &fragmentFormats[i]
+		}
+	}
+	t.Fatalf("no fragment format %q", name)
+	return nil
+}
+
+// fragmentCase is a fragment merged into a target and then removed again.
+type fragmentCase struct {
+	name     string
+	target   string
+	fragment string
+	merged   string // Target after the merge, or the expected error
+}
+
+// testFragmentRoundTrip merges each fragment, checks the result and that a
+// second merge changes nothing, then removes the fragment and checks that
+// the target is back to what it was.
+func testFragmentRoundTrip(t *testing.T, format string, tests []fragmentCase) {
+	f := lookupFragmentFormat(t, format)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, keys, changed, err := f.merge([]byte(tt.target), []byte(tt.fragment), nil)
+			if err != nil {
+				t.Fatalf("merge: %v", err)
+			}
+			if string(merged) != tt.merged {
+				t.Errorf("merged:\n%s\nwant:\n%s", merged, tt.merged)
+			}
+			if changed != (tt.target != tt.merged) {
+				t.Errorf("merge changed = %v, want %v", changed, tt.target != tt.merged)
+			}
+
+			// Keys survive the state file
+			data, err := json.Marshal(keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys = nil
+			if err := json.Unmarshal(data, &keys); err != nil {
+				t.Fatal(err)
+			}
+
+			again, keys, changed, err := f.merge(merged, []byte(tt.fragment), keys)
+			if err != nil {
+				t.Fatalf("second merge: %v", err)
+			}
+			if changed || string(again) != tt.merged {
+				t.Errorf("second merge changed the target:\n%s", again)
+			}
+
+			removed, _, err := f.remove(again, keys)
+			if err != nil {
+				t.Fatalf("remove: %v", err)
+			}
+			if string(removed) != tt.target {
+				t.Errorf("after removal:\n%s\nwant the original:\n%s", removed, tt.target)
+			}
+		})
+	}
+}
+
+// remergeCase is a fragment merged into a target and then replaced by a
+// version that no longer sets some of its keys.
+type remergeCase struct {
+	name   string
+	target string
+	first  string
+	second string
+	want   string // Target after the second merge
+}
+
+// testFragmentRemerge checks that keys dropped from a fragment are undone
+// when it is merged again.
+func testFragmentRemerge(t *testing.T, format string, tests []remergeCase) {
+	f := lookupFragmentFormat(t, format)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, keys, _, err := f.merge([]byte(tt.target), []byte(tt.first), nil)
+			if err != nil {
+				t.Fatalf("first merge: %v", err)
+			}
+			got, _, _, err := f.merge(merged, []byte(tt.second), keys)
+			if err != nil {
+				t.Fatalf("second merge: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// testFragmentErrors checks that merging each fragment into its target fails
+// with an error containing the given text.
+func testFragmentErrors(t *testing.T, format string, tests []fragmentCase) {
+	f := lookupFragmentFormat(t, format)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, _, err := f.merge([]byte(tt.target), []byte(tt.fragment), nil)
+			if err == nil || !strings.Contains(err.Error(), tt.merged) {
+				t.Errorf("got error %v, want one containing %q", err, tt.merged)
+			}
+		})
+	}
+}
+
+func TestJSONFragmentRoundTrip(t *testing.T) {
+	testFragmentRoundTrip(t, "json", []fragmentCase{
+		{
+			name:     "added keys",
+			target:   "{\n  \"a\": 1\n}\n",
+			fragment: `{"b": true, "c": {"d": [1, "x"]}}`,
+			merged:   "{\n  \"a\": 1,\n  \"b\": true,\n  \"c\": {\n    \"d\": [\n      1,\n      \"x\"\n    ]\n  }\n}\n",
+		},
+		{
+			name:     "replaced values are restored in place",
+			target:   "{\n    \"a\": 1,\n    \"b\": {\n        \"x\": \"old\"\n    },\n    \"c\": null\n}\n",
+			fragment: `{"a": "new", "b": 2}`,
+			merged:   "{\n    \"a\": \"new\",\n    \"b\": 2,\n    \"c\": null\n}\n",
+		},
+		{
+			name:     "nested objects are merged key by key",
+			target:   "{\n  \"editor\": {\n    \"size\": 12,\n    \"font\": \"mono\"\n  }\n}\n",
+			fragment: `{"editor": {"size": 14, "tabs": false}}`,
+			merged:   "{\n  \"editor\": {\n    \"size\": 14,\n    \"font\": \"mono\",\n    \"tabs\": false\n  }\n}\n",
+		},
+		{
+			name:     "unchanged target keeps its formatting",
+			target:   "{\"a\": 1}\n",
+			fragment: `{"a": 1}`,
+			merged:   "{\"a\": 1}\n",
+		},
+	})
+}
+
+func TestJSONFragmentRemerge(t *testing.T) {
+	testFragmentRemerge(t, "json", []remergeCase{
+		{
+			name:   "dropped keys are restored or removed",
+			target: "{\n  \"a\": 1\n}\n",
+			first:  `{"a": 2, "b": 3, "c": {"d": 4}}`,
+			second: `{"b": 4}`,
+			want:   "{\n  \"a\": 1,\n  \"b\": 4\n}\n",
+		},
+	})
+}
+
+func TestJSONFragmentErrors(t *testing.T) {
+	testFragmentErrors(t, "json", []fragmentCase{
+		{name: "fragment is not an object", target: "{}", fragment: `[1]`, merged: "parsing fragment"},
+		{name: "comment in target", target: "{\n  // comment\n}", fragment: `{"a": 1}`, merged: "comments and trailing commas are not supported"},
+		{name: "trailing comma in target", target: `{"a": 1,}`, fragment: `{"a": 1}`, merged: "comments and trailing commas are not supported"},
+	})
+}
+
+func TestINIFragmentRoundTrip(t *testing.T) {
+	testFragmentRoundTrip(t, "ini", []fragmentCase{
+		{
+			name:     "added keys and section",
+			target:   "[user]\n\tname = Ann\n\n[core]\n\teditor = vi\n",
+			fragment: "[user]\nemail = ann@example.com\n[pull]\nrebase = true\n",
+			merged:   "[user]\n\tname = Ann\n\temail = ann@example.com\n\n[core]\n\teditor = vi\n\n[pull]\nrebase = true\n",
+		},
+		{
+			name:     "replaced keys are restored",
+			target:   "top = 1\n[core]\n  editor = vi ; old\n  pager = less\n",
+			fragment: "top = 2\n[core]\neditor = nano\n",
+			merged:   "top = 2\n[core]\n  editor = nano\n  pager = less\n",
+		},
+		{
+			name:     "comments in the fragment are skipped",
+			target:   "",
+			fragment: "# comment\n[a]\n; note\nk = v\n",
+			merged:   "[a]\nk = v\n",
+		},
+	})
+}
+
+func TestINIFragmentRemerge(t *testing.T) {
+	testFragmentRemerge(t, "ini", []remergeCase{
+		{
+			name:   "dropped keys and sections are undone",
+			target: "[s]\na = 1\n",
+			first:  "[s]\na = 2\nb = 3\n[t]\nc = 4\n",
+			second: "[s]\nb = 5\n",
+			want:   "[s]\na = 1\nb = 5\n",
+		},
+	})
+}
+
+func TestINIFragmentErrors(t *testing.T) {
+	testFragmentErrors(t, "ini", []fragmentCase{
+		{name: "line without assignment", target: "", fragment: "[s]\nnot a key\n", merged: "line 2 is neither a section header nor a key assignment"},
+		{name: "multi-valued key", target: "", fragment: "[remote \"origin\"]\nfetch = a\nfetch = b\n", merged: "line 3 sets key \"fetch\" again, which line 2 already set"},
+		{name: "key repeated in a repeated section", target: "", fragment: "[s]\nk = 1\n[t]\nk = 2\n[s]\nk = 3\n", merged: "line 6 sets key \"k\" again"},
+	})
+}
+
+func TestLinesFragmentRoundTrip(t *testing.T) {
+	testFragmentRoundTrip(t, "lines", []fragmentCase{
+		{
+			name:     "missing lines are appended",
+			target:   "/bin/sh\n/bin/bash\n",
+			fragment: "/usr/bin/fish\n\n/bin/bash\n",
+			merged:   "/bin/sh\n/bin/bash\n/usr/bin/fish\n",
+		},
+		{
+			name:     "empty target",
+			target:   "",
+			fragment: "a\nb\na\n",
+			merged:   "a\nb\n",
+		},
+		{
+			name:     "duplicates already in the target are kept",
+			target:   "x\nx\n",
+			fragment: "x\ny\n",
+			merged:   "x\nx\ny\n",
+		},
+		{
+			name:     "present lines leave a missing final newline alone",
+			target:   "a\nb",
+			fragment: "b\n",
+			merged:   "a\nb",
+		},
+	})
+}
+
+func TestLinesFragmentRemerge(t *testing.T) {
+	testFragmentRemerge(t, "lines", []remergeCase{
+		{
+			name:   "dropped lines are removed unless they were there before",
+			target: "x\n",
+			first:  "x\ny\nz\n",
+			second: "z\n",
+			want:   "x\nz\n",
+		},
+	})
+}
@@ -11,8 +11,10 @@ package main
 
 import (
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strconv"
+	"time"
 
 	"golang.org/x/sys/unix"
 )
@@ -35,6 +37,16 @@ func setupUmask(umaskStr string) os.FileMode {
 	return os.FileMode(sysMask)
 }
 
+// applyUmask sets the process umask.
+func applyUmask(mask os.FileMode) {
+	unix.Umask(int(mask))
+}
+
+// shellCommand prepares a command line for execution by the system shell.
+func shellCommand(command string) *exec.Cmd {
+	return exec.Command("/bin/sh", "-c", command)
+}
+
 // lockFile acquires an advisory lock on the file descriptor.
 // It blocks until the lock is obtained.
 func lockFile(fd uintptr, exclusive bool) error {
@@ -62,6 +74,27 @@ func ensureStateOwnership(f *os.File, path string) {
 	_ = f.Chown(int(stat.Uid), int(stat.Gid))
 }
 
+// ownershipSupported reports whether destination ownership can be managed.
+// Only root can give files away to other users.
+func ownershipSupported() bool {
+	return os.Getuid() == 0
+}
+
+// fileOwnerOf returns the owner of a path without following symlinks.
+func fileOwnerOf(path string) (fileOwner, error) {
+	var stat unix.Stat_t
+	if err := unix.Lstat(path, &stat); err != nil {
+		return noOwner, err
+	}
+	return fileOwner{uid: int(stat.Uid), gid: int(stat.Gid)}, nil
+}
+
+// setLinkTime sets the modification time of a symlink itself, not of its target.
+func setLinkTime(path string, t time.Time) error {
+	ts := []unix.Timespec{unix.NsecToTimespec(t.UnixNano()), unix.NsecToTimespec(t.UnixNano())}
+	return unix.UtimesNanoAt(unix.AT_FDCWD, path, ts, unix.AT_SYMLINK_NOFOLLOW)
+}
+
 // calculatePerms determines the target file permissions based on Unix conventions.
 func calculatePerms(srcMode os.FileMode, umask os.FileMode, everyone bool) os.FileMode {
 	if !everyone {
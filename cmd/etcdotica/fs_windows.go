@@ -12,6 +12,8 @@ package main
 import (
 	"fmt"
 	"os"
+	"os/exec"
+	"time"
 
 	"golang.org/x/sys/windows"
 )
@@ -21,6 +23,14 @@ func setupUmask(_ string) os.FileMode {
 	return 0
 }
 
+// applyUmask is a no-op on Windows.
+func applyUmask(_ os.FileMode) {}
+
+// shellCommand prepares a command line for execution by cmd.exe.
+func shellCommand(command string) *exec.Cmd {
+	return exec.Command("cmd", "/C", command)
+}
+
 // lockFile acquires an exclusive or shared lock on the file.
 // It matches Unix Flock behavior by blocking until the lock is acquired.
 func lockFile(fd uintptr, exclusive bool) error {
@@ -42,6 +52,35 @@ func lockFile(fd uintptr, exclusive bool) error {
 // ensureStateOwnership is a no-op on Windows.
 func ensureStateOwnership(_ *os.File, _ string) {}
 
+// ownershipSupported reports whether destination ownership can be managed.
+// Windows has no uid/gid ownership, so it never can.
+func ownershipSupported() bool {
+	return false
+}
+
+// fileOwnerOf is a no-op on Windows.
+func fileOwnerOf(_ string) (fileOwner, error) {
+	return noOwner, nil
+}
+
+// setLinkTime sets the modification time of a symlink itself, not of its target.
+func setLinkTime(path string, t time.Time) error {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	h, err := windows.CreateFile(p, windows.FILE_WRITE_ATTRIBUTES,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE, nil,
+		windows.OPEN_EXISTING, windows.FILE_FLAG_OPEN_REPARSE_POINT|windows.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(h)
+
+	ft := windows.NsecToFiletime(t.UnixNano())
+	return windows.SetFileTime(h, nil, &ft, &ft)
+}
+
 // calculatePerms returns the source permissions as-is for Windows.
 // Complex permission mapping is skipped to fit Windows file attributes.
 func calculatePerms(srcMode os.FileMode, _ os.FileMode, _ bool) os.FileMode {
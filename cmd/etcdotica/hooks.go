@@ -0,0 +1,96 @@
+// Copyright 2025-2026 Stanislav Senotrusov
+//
+// This work is dual-licensed under the Apache License, Version 2.0 and the MIT License.
+// See LICENSE-APACHE and LICENSE-MIT in the top-level directory for details.
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// hook is a command run after a change to a destination path matching Pattern.
+type hook struct {
+	Pattern string // gitignore-style glob relative to the destination directory
+	Command string // Shell command line
+	glob    globPattern
+}
+
+// newHook compiles a hook definition.
+func newHook(pattern, command string) (hook, error) {
+	if strings.TrimSpace(command) == "" {
+		return hook{}, fmt.Errorf("hook for %q has an empty command", pattern)
+	}
+	g, ok, err := compileGlob(pattern)
+	if err != nil {
+		return hook{}, fmt.Errorf("hook pattern %q: %v", pattern, err)
+	}
+	if !ok || g.negate {
+		return hook{}, fmt.Errorf("invalid hook pattern %q", pattern)
+	}
+	return hook{Pattern: pattern, Command: command, glob: g}, nil
+}
+
+// parseHookSpec parses a "PATTERN=COMMAND" command line value.
+func parseHookSpec(spec string) (hook, error) {
+	pattern, command, ok := strings.Cut(spec, "=")
+	if !ok {
+		return hook{}, fmt.Errorf("hook %q must have the form PATTERN=COMMAND", spec)
+	}
+	return newHook(strings.TrimSpace(pattern), command)
+}
+
+// touched notes that a destination path was successfully changed during this run.
+func (s *syncer) touched(dstPath string) {
+	if len(s.cfg.Hooks) == 0 {
+		return
+	}
+	relPath, err := filepath.Rel(s.cfg.Dst, dstPath)
+	if err != nil {
+		return
+	}
+	s.changedTargets = append(s.changedTargets, filepath.ToSlash(relPath))
+}
+
+// runHooks runs each hook whose pattern matches at least one path changed
+// during this run. Every hook runs at most once per run, in definition order,
+// and receives the matching paths (newline-separated, relative to the
+// destination) in the ETCDOTICA_PATHS environment variable.
+func (s *syncer) runHooks() {
+	for _, h := range s.cfg.Hooks {
+		var matched []string
+		for _, relPath := range s.changedTargets {
+			if h.glob.matchTree(relPath) && !slices.Contains(matched, relPath) {
+				matched = append(matched, relPath)
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+
+		if s.cfg.DryRun {
+			s.record(actionHook, "", h.Command, strings.Join(matched, ", "))
+			continue
+		}
+
+		logger.Info("Running hook", "command", h.Command, "paths", strings.Join(matched, ","))
+
+		cmd := shellCommand(h.Command)
+		cmd.Dir = s.cfg.Dst
+		cmd.Env = append(os.Environ(), "ETCDOTICA_PATHS="+strings.Join(matched, "\n"))
+		// Standard output is reserved for diffs and reports, so hook output goes to stderr.
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+
+		if err := cmd.Run(); err != nil {
+			logger.Error("Hook failed", "command", h.Command, "err", err)
+			s.hasErrors = true
+		}
+	}
+}
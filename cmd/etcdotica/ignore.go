@@ -0,0 +1,237 @@
+// Copyright 2025-2026 Stanislav Senotrusov
+//
+// This work is dual-licensed under the Apache License, Version 2.0 and the MIT License.
+// See LICENSE-APACHE and LICENSE-MIT in the top-level directory for details.
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreFileName is the per-directory file listing source paths to exclude from sync.
+const ignoreFileName = ".etcdoticaignore"
+
+// globPattern is a compiled gitignore-style pattern.
+type globPattern struct {
+	negate  bool           // Pattern starts with "!" and re-includes matching paths
+	dirOnly bool           // Pattern ends with "/" and matches directories only
+	rx      *regexp.Regexp // Matches slash-separated paths relative to the base
+}
+
+// ignoreRule is a pattern together with the directory whose ignore file defined it.
+type ignoreRule struct {
+	base    string // Slash-separated directory relative to the source root ("" for the root)
+	pattern globPattern
+}
+
+// ignoreMatcher accumulates ignore rules as the walk descends the source tree.
+// Later rules take precedence over earlier ones, so rules from deeper
+// directories override those from their parents, as in gitignore.
+type ignoreMatcher struct {
+	rules []ignoreRule
+}
+
+// load reads the ignore files of a source directory and appends their rules.
+// relDir is the directory relative to the source root.
+func (m *ignoreMatcher) load(absDir, relDir string, gitIgnore bool) error {
+	names := []string{ignoreFileName}
+	if gitIgnore {
+		// .etcdoticaignore is read last so it can override .gitignore
+		names = []string{".gitignore", ignoreFileName}
+	}
+
+	base := filepath.ToSlash(relDir)
+	if base == "." {
+		base = ""
+	}
+
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(absDir, name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		for i, line := range splitLines(data) {
+			pattern, ok, err := compileGlob(line)
+			if err != nil {
+				return fmt.Errorf("%s line %d: %v", filepath.Join(relDir, name), i+1, err)
+			}
+			if ok {
+				m.rules = append(m.rules, ignoreRule{base: base, pattern: pattern})
+			}
+		}
+	}
+	return nil
+}
+
+// ignored reports whether the path relative to the source root is excluded.
+func (m *ignoreMatcher) ignored(relPath string, isDir bool) bool {
+	relPath = filepath.ToSlash(relPath)
+	result := false
+	for _, r := range m.rules {
+		sub := relPath
+		if r.base != "" {
+			if !strings.HasPrefix(relPath, r.base+"/") {
+				continue
+			}
+			sub = relPath[len(r.base)+1:]
+		}
+		if r.pattern.match(sub, isDir) {
+			result = !r.pattern.negate
+		}
+	}
+	return result
+}
+
+// match reports whether the pattern matches a slash-separated relative path.
+func (g globPattern) match(relPath string, isDir bool) bool {
+	if g.dirOnly && !isDir {
+		return false
+	}
+	return g.rx.MatchString(relPath)
+}
+
+// matchTree reports whether the pattern matches a file path or any of its
+// parent directories, so that a directory pattern covers everything inside it.
+func (g globPattern) matchTree(relPath string) bool {
+	if g.match(relPath, false) {
+		return true
+	}
+	for dir := path.Dir(relPath); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if g.match(dir, true) {
+			return true
+		}
+	}
+	return false
+}
+
+// compileGlob compiles a single gitignore-style line.
+// It returns ok=false for blank lines and comments.
+func compileGlob(line string) (globPattern, bool, error) {
+	line = strings.TrimSuffix(line, "\r")
+
+	// Trailing spaces are ignored unless escaped with a backslash
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return globPattern{}, false, nil
+	}
+
+	var g globPattern
+	switch {
+	case strings.HasPrefix(line, "!"):
+		g.negate = true
+		line = line[1:]
+	case strings.HasPrefix(line, `\!`), strings.HasPrefix(line, `\#`):
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		g.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return globPattern{}, false, nil
+	}
+
+	// A slash at the beginning or in the middle anchors the pattern to the
+	// directory of the ignore file; otherwise it matches at any depth.
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	expr, err := globToRegexp(line)
+	if err != nil {
+		return globPattern{}, false, err
+	}
+	if !anchored {
+		expr = "(?:.*/)?" + expr
+	}
+
+	rx, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return globPattern{}, false, err
+	}
+	g.rx = rx
+	return g, true, nil
+}
+
+// compileGlobs compiles a list of patterns that select paths, such as the
+// values of a repeated flag. Negated patterns are not allowed.
+func compileGlobs(patterns []string) ([]globPattern, error) {
+	var globs []globPattern
+	for _, p := range patterns {
+		g, ok, err := compileGlob(p)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %q: %v", p, err)
+		}
+		if !ok || g.negate {
+			return nil, fmt.Errorf("invalid pattern %q", p)
+		}
+		globs = append(globs, g)
+	}
+	return globs, nil
+}
+
+// globToRegexp translates a slash-separated glob into a regular expression.
+// It supports "*", "?", character classes, backslash escapes and "**"
+// as a whole path segment.
+func globToRegexp(glob string) (string, error) {
+	var sb strings.Builder
+	segments := strings.Split(glob, "/")
+
+	for i, seg := range segments {
+		last := i == len(segments)-1
+
+		if seg == "**" {
+			if last {
+				sb.WriteString(".*") // "dir/**" matches everything inside dir
+			} else {
+				sb.WriteString("(?:.*/)?") // Zero or more directories
+			}
+			continue
+		}
+
+		for j := 0; j < len(seg); j++ {
+			c := seg[j]
+			switch c {
+			case '*':
+				sb.WriteString("[^/]*")
+			case '?':
+				sb.WriteString("[^/]")
+			case '\\':
+				if j+1 < len(seg) {
+					j++
+					sb.WriteString(regexp.QuoteMeta(string(seg[j])))
+				}
+			case '[':
+				end := strings.IndexByte(seg[j+1:], ']')
+				if end < 0 {
+					return "", fmt.Errorf("unterminated character class in %q", glob)
+				}
+				class := seg[j+1 : j+1+end]
+				if strings.HasPrefix(class, "!") {
+					class = "^" + class[1:]
+				}
+				sb.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+				j += end + 1
+			default:
+				sb.WriteString(regexp.QuoteMeta(string(c)))
+			}
+		}
+		if !last {
+			sb.WriteByte('/')
+		}
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2025-2026 Stanislav Senotrusov
+//
+// This work is dual-licensed under the Apache License, Version 2.0 and the MIT License.
+// See LICENSE-APACHE and LICENSE-MIT in the top-level directory for details.
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompileGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		isDir   bool
+		want    bool
+	}{
+		// Unanchored patterns match at any depth
+		{"*.bak", "x.bak", false, true},
+		{"*.bak", "a/b/x.bak", false, true},
+		{"*.bak", "x.bak.txt", false, false},
+		{"secret", "a/secret", true, true},
+
+		// A leading or inner slash anchors the pattern to its directory
+		{"/secret", "secret", false, true},
+		{"/secret", "a/secret", false, false},
+		{"a/secret", "a/secret", false, true},
+		{"a/secret", "b/a/secret", false, false},
+
+		// Wildcards do not cross slashes
+		{"a/*", "a/b", false, true},
+		{"a/*", "a/b/c", false, false},
+		{"a?c", "abc", false, true},
+		{"a?c", "a/c", false, false},
+		{"[ab]x", "bx", false, true},
+		{"[!ab]x", "bx", false, false},
+		{"[!ab]x", "cx", false, true},
+
+		// "**" matches any number of directories
+		{"**/logs", "logs", true, true},
+		{"**/logs", "a/b/logs", true, true},
+		{"a/**/z", "a/z", false, true},
+		{"a/**/z", "a/b/c/z", false, true},
+		{"a/**/z", "b/a/z", false, false},
+		{"a/**", "a/b/c", false, true},
+
+		// A trailing slash matches directories only
+		{"cache/", "cache", true, true},
+		{"cache/", "cache", false, false},
+		{"cache/", "a/cache", true, true},
+
+		// Escapes
+		{`\#notes`, "#notes", false, true},
+		{`\!important`, "!important", false, true},
+		{`a\*`, "a*", false, true},
+		{`a\*`, "ab", false, false},
+		{"trailing\\ ", "trailing ", false, true},
+	}
+
+	for _, tt := range tests {
+		g, ok, err := compileGlob(tt.pattern)
+		if err != nil || !ok {
+			t.Errorf("compileGlob(%q) = ok %v, err %v", tt.pattern, ok, err)
+			continue
+		}
+		if got := g.match(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("%q matching %q (dir %v) = %v, want %v", tt.pattern, tt.path, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestCompileGlobSkipsAndErrors(t *testing.T) {
+	for _, line := range []string{"", "   ", "# comment", "/", "!"} {
+		if _, ok, err := compileGlob(line); ok || err != nil {
+			t.Errorf("compileGlob(%q) = ok %v, err %v, want it skipped", line, ok, err)
+		}
+	}
+	if _, _, err := compileGlob("a[b"); err == nil {
+		t.Error(`compileGlob("a[b") succeeded, want an error`)
+	}
+	if _, err := compileGlobs([]string{"!x"}); err == nil {
+		t.Error("compileGlobs accepted a negated pattern")
+	}
+}
+
+func TestIgnoreMatcher(t *testing.T) {
+	src := t.TempDir()
+	files := map[string]string{
+		ignoreFileName:                 "*.log\n/build/\nsecrets\n",
+		".gitignore":                   "*.tmp\n",
+		"app/" + ignoreFileName:        "!keep.log\n/local\n",
+		"app/nested/" + ignoreFileName: "*.log\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	load := func(gitIgnore bool) *ignoreMatcher {
+		var m ignoreMatcher
+		for _, dir := range []string{".", "app", "app/nested"} {
+			if err := m.load(filepath.Join(src, dir), dir, gitIgnore); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return &m
+	}
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"x.log", false, true},
+		{"app/x.log", false, true},
+		{"app/keep.log", false, false},       // Re-included by a deeper negation
+		{"app/nested/keep.log", false, true}, // Ignored again further down
+		{"build", true, true},
+		{"build", false, false},
+		{"app/build", true, false}, // Anchored to the root
+		{"local", false, false},    // Anchored to app
+		{"app/local", false, true},
+		{"app/secrets", true, true},
+		{"x.tmp", false, false},
+	}
+	m := load(false)
+	for _, tt := range tests {
+		if got := m.ignored(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("ignored(%q, dir %v) = %v, want %v", tt.path, tt.isDir, got, tt.want)
+		}
+	}
+
+	if !load(true).ignored("app/x.tmp", false) {
+		t.Error("rules of .gitignore are not applied with gitIgnore")
+	}
+}
+
+func TestIgnoreMatcherLoadError(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ignoreFileName), []byte("ok\nbad[\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var m ignoreMatcher
+	err := m.load(dir, "sub", false)
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("got error %v, want one naming line 2", err)
+	}
+}
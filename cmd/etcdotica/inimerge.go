@@ -0,0 +1,284 @@
+// Copyright 2025-2026 Stanislav Senotrusov
+//
+// This work is dual-licensed under the Apache License, Version 2.0 and the MIT License.
+// See LICENSE-APACHE and LICENSE-MIT in the top-level directory for details.
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// iniLine classifies a line of an INI file. Comments, blank lines and
+// anything else that is neither a section header nor a key are "other" lines.
+type iniLine struct {
+	header  bool
+	key     string // Empty unless the line assigns a key
+	section string // For headers, the section name
+}
+
+// parseINILine classifies a line. Headers look like "[name]" or "[remote "origin"]",
+// and keys like "key = value"; lines starting with "#" or ";" are comments.
+func parseINILine(line string) iniLine {
+	trimmed := strings.TrimSpace(line)
+	switch {
+	case trimmed == "", strings.HasPrefix(trimmed, "#"), strings.HasPrefix(trimmed, ";"):
+		return iniLine{}
+	case strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]"):
+		return iniLine{header: true, section: strings.TrimSpace(trimmed[1 : len(trimmed)-1])}
+	}
+	if key, _, ok := strings.Cut(trimmed, "="); ok {
+		return iniLine{key: strings.TrimSpace(key)}
+	}
+	return iniLine{}
+}
+
+// iniSetting is a key set by a fragment.
+type iniSetting struct {
+	section string // Empty for keys before the first header
+	key     string
+	line    string // The assignment as written in the fragment, without indentation
+}
+
+// parseINIFragment reads the headers and keys of a fragment. Comments and
+// blank lines are skipped; any other line is an error. Multi-valued keys are
+// not supported, so a key set twice in a section is an error as well.
+func parseINIFragment(content []byte) ([]iniSetting, []string, error) {
+	var settings []iniSetting
+	var sections []string
+	section := ""
+	defined := make(map[[2]string]int) // Line number of each section and key
+	for i, line := range splitLines(content) {
+		trimmed := strings.TrimSpace(line)
+		l := parseINILine(line)
+		switch {
+		case l.header:
+			section = l.section
+			if !slices.Contains(sections, section) {
+				sections = append(sections, section)
+			}
+		case l.key != "":
+			if first, ok := defined[[2]string{section, l.key}]; ok {
+				return nil, nil, fmt.Errorf("line %d sets key %q again, which line %d already set; multi-valued keys are not supported", i+1, l.key, first)
+			}
+			defined[[2]string{section, l.key}] = i + 1
+			settings = append(settings, iniSetting{section: section, key: l.key, line: trimmed})
+		case trimmed != "" && trimmed[0] != '#' && trimmed[0] != ';':
+			return nil, nil, fmt.Errorf("line %d is neither a section header nor a key assignment", i+1)
+		}
+	}
+	return settings, sections, nil
+}
+
+// iniSectionBounds returns the index of the last header of a section and the
+// end of the block it starts (the next header or the end of the file). Keys
+// before the first header form the "" section, whose header index is -1.
+// found is false if a named section has no header.
+func iniSectionBounds(lines []string, section string) (header, end int, found bool) {
+	header = -1
+	if section != "" {
+		for i, line := range lines {
+			if l := parseINILine(line); l.header && l.section == section {
+				header = i
+			}
+		}
+		if header < 0 {
+			return -1, 0, false
+		}
+	}
+	for end = header + 1; end < len(lines); end++ {
+		if parseINILine(lines[end]).header {
+			break
+		}
+	}
+	return header, end, true
+}
+
+// findINIKey returns the index of the last assignment of a key in a section, or -1.
+// Assignments in every block of a repeated section count.
+func findINIKey(lines []string, section, key string) int {
+	idx := -1
+	current := ""
+	for i, line := range lines {
+		l := parseINILine(line)
+		if l.header {
+			current = l.section
+		} else if l.key == key && current == section {
+			idx = i
+		}
+	}
+	return idx
+}
+
+// indentOf returns the leading whitespace of a line.
+func indentOf(line string) string {
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// iniKeyID joins a section and key into a map key.
+func iniKeyID(section, key string) string {
+	return section + "\x00" + key
+}
+
+// insertINIKey adds a key line to a section, after its last non-blank line,
+// indented like the keys already there. A missing section is appended to the
+// end of the file; it reports whether it had to be created.
+func insertINIKey(lines []string, section, keyLine string) ([]string, bool) {
+	header, end, found := iniSectionBounds(lines, section)
+	if !found {
+		if len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" {
+			lines = append(lines, "")
+		}
+		return append(lines, "["+section+"]", keyLine), true
+	}
+
+	pos := end
+	for pos > header+1 && strings.TrimSpace(lines[pos-1]) == "" {
+		pos--
+	}
+	indent := ""
+	for i := header + 1; i < end; i++ {
+		if parseINILine(lines[i]).key != "" {
+			indent = indentOf(lines[i])
+		}
+	}
+	return slices.Insert(lines, pos, indent+keyLine), false
+}
+
+// undoINIKey restores the line a key replaced, or removes the key if it was added.
+func undoINIKey(lines []string, k mergedKey) ([]string, bool) {
+	idx := findINIKey(lines, k.Path[0], k.Path[1])
+	if idx < 0 {
+		return lines, false
+	}
+	if k.Prior != nil {
+		if lines[idx] == *k.Prior {
+			return lines, false
+		}
+		lines[idx] = *k.Prior
+		return lines, true
+	}
+	return slices.Delete(lines, idx, idx+1), true
+}
+
+// removeEmptyINISection removes the header of a section created by a
+// fragment once no key or comment is left in it, together with the blank
+// lines that separated it from the rest of the file.
+func removeEmptyINISection(lines []string, section string) ([]string, bool) {
+	header, end, found := iniSectionBounds(lines, section)
+	if !found || header < 0 {
+		return lines, false
+	}
+	for i := header + 1; i < end; i++ {
+		if strings.TrimSpace(lines[i]) != "" {
+			return lines, false
+		}
+	}
+	start := header
+	if end == len(lines) {
+		for start > 0 && strings.TrimSpace(lines[start-1]) == "" {
+			start--
+		}
+	}
+	return slices.Delete(lines, start, end), true
+}
+
+// undoINIKeys undoes merged keys: keys first, then the sections that were
+// created for them.
+func undoINIKeys(lines []string, keys []mergedKey) []string {
+	for _, k := range keys {
+		if len(k.Path) == 2 {
+			lines, _ = undoINIKey(lines, k)
+		}
+	}
+	for _, k := range keys {
+		if len(k.Path) == 1 {
+			lines, _ = removeEmptyINISection(lines, k.Path[0])
+		}
+	}
+	return lines
+}
+
+// computeMergedINI sets the fragment's keys inside the matching sections of
+// the target content, creating sections that are missing. Existing keys are
+// replaced in place, keeping their indentation; every other line is kept as
+// it is. keys are the keys the fragment set on its previous merge: those it no
+// longer sets are restored to their prior line, or removed if they were added.
+// The returned keys record the section and key of every assignment, with the
+// line it replaced, and as a one-element path every section that was created.
+func computeMergedINI(oldContent, fragmentContent []byte, keys []mergedKey) ([]byte, []mergedKey, bool, error) {
+	settings, sections, err := parseINIFragment(fragmentContent)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("parsing fragment: %v", err)
+	}
+
+	owned := make(map[string]mergedKey)
+	for _, k := range keys {
+		owned[strings.Join(k.Path, "\x00")] = k
+	}
+
+	// Undo the keys and sections the fragment no longer sets
+	set := make(map[string]bool)
+	for _, st := range settings {
+		set[iniKeyID(st.section, st.key)] = true
+	}
+	var stale []mergedKey
+	for _, k := range keys {
+		if (len(k.Path) == 2 && !set[iniKeyID(k.Path[0], k.Path[1])]) ||
+			(len(k.Path) == 1 && !slices.Contains(sections, k.Path[0])) {
+			stale = append(stale, k)
+		}
+	}
+	lines := undoINIKeys(splitLines(oldContent), stale)
+
+	var contributed []mergedKey
+	for _, section := range sections {
+		if k, ok := owned[section]; ok {
+			contributed = append(contributed, k)
+		}
+	}
+
+	for _, st := range settings {
+		id := iniKeyID(st.section, st.key)
+		k := mergedKey{Path: []string{st.section, st.key}}
+		if prev, ok := owned[id]; ok {
+			k.Prior = prev.Prior // The current line is ours; keep what it replaced
+		}
+
+		if idx := findINIKey(lines, st.section, st.key); idx >= 0 {
+			if _, ok := owned[id]; !ok {
+				prior := lines[idx]
+				k.Prior = &prior
+			}
+			lines[idx] = indentOf(lines[idx]) + st.line
+		} else {
+			var created bool
+			lines, created = insertINIKey(lines, st.section, st.line)
+			if _, ok := owned[st.section]; created && !ok {
+				contributed = append(contributed, mergedKey{Path: []string{st.section}})
+			}
+		}
+		contributed = append(contributed, k)
+	}
+
+	newBytes := joinLines(lines)
+	if bytes.Equal(oldContent, newBytes) {
+		return oldContent, contributed, false, nil
+	}
+	return newBytes, contributed, true, nil
+}
+
+// computeRemovedINI restores or removes the keys of a deleted fragment and
+// removes the sections created for them once they are empty.
+func computeRemovedINI(oldContent []byte, keys []mergedKey) ([]byte, bool, error) {
+	newBytes := joinLines(undoINIKeys(splitLines(oldContent), keys))
+	if bytes.Equal(oldContent, newBytes) {
+		return oldContent, false, nil
+	}
+	return newBytes, true, nil
+}
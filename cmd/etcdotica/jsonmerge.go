@@ -0,0 +1,386 @@
+// Copyright 2025-2026 Stanislav Senotrusov
+//
+// This work is dual-licensed under the Apache License, Version 2.0 and the MIT License.
+// See LICENSE-APACHE and LICENSE-MIT in the top-level directory for details.
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// defaultJSONIndent is used for targets whose indentation cannot be determined.
+const defaultJSONIndent = "  "
+
+// jsonObject is a JSON object that remembers the order of its keys, so that
+// rewriting a target file keeps its layout as far as possible.
+type jsonObject struct {
+	keys   []string
+	values map[string]any
+}
+
+func newJSONObject() *jsonObject {
+	return &jsonObject{values: make(map[string]any)}
+}
+
+// get returns the value of a key.
+func (o *jsonObject) get(key string) (any, bool) {
+	v, ok := o.values[key]
+	return v, ok
+}
+
+// set replaces the value of a key in place, or appends the key if it is new.
+func (o *jsonObject) set(key string, value any) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+// remove deletes a key and reports whether it was present.
+func (o *jsonObject) remove(key string) bool {
+	if _, ok := o.values[key]; !ok {
+		return false
+	}
+	delete(o.values, key)
+	for i, k := range o.keys {
+		if k == key {
+			o.keys = append(o.keys[:i], o.keys[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// MarshalJSON encodes the object compactly, keeping the order of its keys.
+func (o *jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		writeJSONString(&buf, k)
+		buf.WriteByte(':')
+		value, err := json.Marshal(o.values[k])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// decodeJSON parses a JSON document. Objects are decoded as *jsonObject,
+// arrays as []any and numbers as json.Number, so they are written back verbatim.
+func decodeJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	v, err := decodeJSONValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the top-level value")
+	}
+	return v, nil
+}
+
+// decodeJSONValue reads the next value from the decoder's token stream.
+func decodeJSONValue(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return tok, nil // string, json.Number, bool or nil
+	}
+
+	switch delim {
+	case '{':
+		obj := newJSONObject()
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key, ok := keyTok.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid object key %v", keyTok)
+			}
+			value, err := decodeJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj.set(key, value)
+		}
+		_, err := dec.Token() // Closing brace
+		return obj, err
+
+	case '[':
+		arr := []any{}
+		for dec.More() {
+			value, err := decodeJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, value)
+		}
+		_, err := dec.Token() // Closing bracket
+		return arr, err
+	}
+	return nil, fmt.Errorf("unexpected delimiter %v", delim)
+}
+
+// decodeJSONObject parses a document whose top-level value must be an object.
+// Empty content yields an empty object.
+func decodeJSONObject(data []byte) (*jsonObject, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return newJSONObject(), nil
+	}
+	v, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := v.(*jsonObject)
+	if !ok {
+		return nil, fmt.Errorf("top-level value is not an object")
+	}
+	return obj, nil
+}
+
+// encodeJSON formats a value with one member or element per line, followed by a newline.
+func encodeJSON(v any, indent string) []byte {
+	var buf bytes.Buffer
+	writeJSONValue(&buf, v, indent, 0)
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+func writeJSONValue(buf *bytes.Buffer, v any, indent string, depth int) {
+	switch v := v.(type) {
+	case *jsonObject:
+		if len(v.keys) == 0 {
+			buf.WriteString("{}")
+			return
+		}
+		buf.WriteString("{\n")
+		for i, k := range v.keys {
+			buf.WriteString(strings.Repeat(indent, depth+1))
+			writeJSONString(buf, k)
+			buf.WriteString(": ")
+			writeJSONValue(buf, v.values[k], indent, depth+1)
+			if i < len(v.keys)-1 {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(strings.Repeat(indent, depth) + "}")
+	case []any:
+		if len(v) == 0 {
+			buf.WriteString("[]")
+			return
+		}
+		buf.WriteString("[\n")
+		for i, e := range v {
+			buf.WriteString(strings.Repeat(indent, depth+1))
+			writeJSONValue(buf, e, indent, depth+1)
+			if i < len(v)-1 {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(strings.Repeat(indent, depth) + "]")
+	case string:
+		writeJSONString(buf, v)
+	case json.Number:
+		buf.WriteString(v.String())
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case nil:
+		buf.WriteString("null")
+	}
+}
+
+// writeJSONString writes a quoted string without escaping HTML characters.
+func writeJSONString(buf *bytes.Buffer, s string) {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s)           // Encoding a string cannot fail
+	buf.Truncate(buf.Len() - 1) // Drop the newline added by Encode
+}
+
+// detectJSONIndent returns the indentation of the first indented line of a
+// document, which is one level deep in a formatted top-level object.
+func detectJSONIndent(content []byte) string {
+	for _, line := range splitLines(content) {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && len(trimmed) < len(line) {
+			return line[:len(line)-len(trimmed)]
+		}
+	}
+	return defaultJSONIndent
+}
+
+// jsonKeyID joins a key path into a map key.
+func jsonKeyID(path []string) string {
+	return strings.Join(path, "\x00")
+}
+
+// mergeJSONObject deep-merges the fragment object into the target object.
+// Nested objects present on both sides are merged key by key, anything else
+// is replaced. Keys in owned, contributed by an earlier merge of the same
+// fragment, are replaced as a whole. Every key set from the fragment is
+// appended to contributed, with the encoded value it replaced, if any.
+func mergeJSONObject(target, fragment *jsonObject, path []string, owned map[string]mergedKey, contributed *[]mergedKey) error {
+	for _, key := range fragment.keys {
+		keyPath := append(append([]string{}, path...), key)
+		value := fragment.values[key]
+
+		current, exists := target.get(key)
+		currentObj, currentIsObj := current.(*jsonObject)
+		valueObj, valueIsObj := value.(*jsonObject)
+		prev, isOwned := owned[jsonKeyID(keyPath)]
+		if exists && currentIsObj && valueIsObj && !isOwned {
+			if err := mergeJSONObject(currentObj, valueObj, keyPath, owned, contributed); err != nil {
+				return err
+			}
+			continue
+		}
+
+		k := mergedKey{Path: keyPath, Prior: prev.Prior} // An owned value keeps what it replaced
+		if exists && !isOwned {
+			prior, err := json.Marshal(current)
+			if err != nil {
+				return err
+			}
+			encoded := string(prior)
+			k.Prior = &encoded
+		}
+		target.set(key, value)
+		*contributed = append(*contributed, k)
+	}
+	return nil
+}
+
+// parentJSONObject returns the object holding the last key of a path.
+func parentJSONObject(root *jsonObject, path []string) (*jsonObject, bool) {
+	obj := root
+	for _, key := range path[:len(path)-1] {
+		v, _ := obj.get(key)
+		next, ok := v.(*jsonObject)
+		if !ok {
+			return nil, false
+		}
+		obj = next
+	}
+	return obj, true
+}
+
+// undoJSONKey restores the value a merged key replaced, or deletes the key if
+// the fragment added it.
+func undoJSONKey(root *jsonObject, k mergedKey) error {
+	obj, ok := parentJSONObject(root, k.Path)
+	if !ok {
+		return nil
+	}
+	key := k.Path[len(k.Path)-1]
+	if k.Prior == nil {
+		obj.remove(key)
+		return nil
+	}
+	prior, err := decodeJSON([]byte(*k.Prior))
+	if err != nil {
+		return fmt.Errorf("prior value of %s: %v", strings.Join(k.Path, "."), err)
+	}
+	obj.set(key, prior)
+	return nil
+}
+
+// computeMergedJSON merges a fragment into the target content. keys are the
+// key paths the fragment contributed on its previous merge; those that the
+// fragment no longer sets are removed. It returns the new content, the key
+// paths the fragment now contributes, and whether the content changed.
+// Unchanged targets keep their formatting.
+func computeMergedJSON(oldContent, fragmentContent []byte, keys []mergedKey) ([]byte, []mergedKey, bool, error) {
+	fragment, err := decodeJSONObject(fragmentContent)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("parsing fragment: %v", err)
+	}
+	root, err := decodeJSONObject(oldContent)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("parsing target file (comments and trailing commas are not supported): %v", err)
+	}
+
+	indent := detectJSONIndent(oldContent)
+	before := encodeJSON(root, indent)
+
+	owned := make(map[string]mergedKey)
+	for _, k := range keys {
+		owned[jsonKeyID(k.Path)] = k
+	}
+
+	var contributed []mergedKey
+	if err := mergeJSONObject(root, fragment, nil, owned, &contributed); err != nil {
+		return nil, nil, false, err
+	}
+
+	// Undo keys the fragment set before but no longer does. A key is still
+	// set if it, or an object containing it, was contributed again.
+	current := make(map[string]bool)
+	for _, k := range contributed {
+		current[jsonKeyID(k.Path)] = true
+	}
+	for _, k := range keys {
+		covered := false
+		for i := 1; i <= len(k.Path); i++ {
+			if current[jsonKeyID(k.Path[:i])] {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			if err := undoJSONKey(root, k); err != nil {
+				return nil, nil, false, err
+			}
+		}
+	}
+
+	after := encodeJSON(root, indent)
+	if bytes.Equal(before, after) && len(oldContent) > 0 {
+		return oldContent, contributed, false, nil
+	}
+	return after, contributed, true, nil
+}
+
+// computeRemovedJSON restores the values the contributed keys replaced, and
+// removes the keys the fragment added. Objects that become empty are kept, as
+// they may predate the fragment.
+func computeRemovedJSON(oldContent []byte, keys []mergedKey) ([]byte, bool, error) {
+	root, err := decodeJSONObject(oldContent)
+	if err != nil {
+		return nil, false, fmt.Errorf("parsing target file (comments and trailing commas are not supported): %v", err)
+	}
+
+	indent := detectJSONIndent(oldContent)
+	before := encodeJSON(root, indent)
+	for _, k := range keys {
+		if err := undoJSONKey(root, k); err != nil {
+			return nil, false, err
+		}
+	}
+	after := encodeJSON(root, indent)
+	if bytes.Equal(before, after) {
+		return oldContent, false, nil
+	}
+	return after, true, nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2025-2026 Stanislav Senotrusov
+//
+// This work is dual-licensed under the Apache License, Version 2.0 and the MIT License.
+// See LICENSE-APACHE and LICENSE-MIT in the top-level directory for details.
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// computeMergedLines ensures that every non-blank line of the fragment is
+// present in the target content: missing lines are appended to the end, and
+// further copies of the lines it added are removed. keys are the lines
+// the fragment added on its previous merge; those it no longer lists are
+// removed. Only added lines are returned as contributed, so lines that were
+// in the target before, including any duplicates of them, are left in place
+// when the fragment is removed.
+func computeMergedLines(oldContent, fragmentContent []byte, keys []mergedKey) ([]byte, []mergedKey, bool, error) {
+	var wanted []string
+	for _, line := range splitLines(fragmentContent) {
+		if strings.TrimSpace(line) != "" && !slices.Contains(wanted, line) {
+			wanted = append(wanted, line)
+		}
+	}
+
+	owned := make(map[string]bool)
+	for _, k := range keys {
+		owned[k.Path[0]] = true
+	}
+
+	oldLines := splitLines(oldContent)
+	var lines []string
+	seen := make(map[string]bool)
+	for _, line := range oldLines {
+		switch {
+		case owned[line] && !slices.Contains(wanted, line):
+			continue // Added by us, no longer wanted
+		case owned[line] && seen[line]:
+			continue // Extra copy of a line we added
+		case slices.Contains(wanted, line):
+			seen[line] = true
+		}
+		lines = append(lines, line)
+	}
+
+	var contributed []mergedKey
+	for _, line := range wanted {
+		if !seen[line] {
+			lines = append(lines, line)
+		} else if !owned[line] {
+			continue // Present before the fragment was merged
+		}
+		contributed = append(contributed, mergedKey{Path: []string{line}})
+	}
+
+	// Unchanged lines keep the target as it is, even without a final newline
+	if slices.Equal(oldLines, lines) {
+		return oldContent, contributed, false, nil
+	}
+	return joinLines(lines), contributed, true, nil
+}
+
+// computeRemovedLines removes every copy of the lines a deleted fragment added.
+func computeRemovedLines(oldContent []byte, keys []mergedKey) ([]byte, bool, error) {
+	owned := make(map[string]bool)
+	for _, k := range keys {
+		owned[k.Path[0]] = true
+	}
+
+	oldLines := splitLines(oldContent)
+	var lines []string
+	for _, line := range oldLines {
+		if !owned[line] {
+			lines = append(lines, line)
+		}
+	}
+
+	if slices.Equal(oldLines, lines) {
+		return oldContent, false, nil
+	}
+	return joinLines(lines), true, nil
+}
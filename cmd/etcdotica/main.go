@@ -9,6 +9,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
@@ -17,6 +18,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
 	"strings"
 	"time"
 )
@@ -35,14 +37,57 @@ func (s *stringArray) Set(value string) error {
 
 // Config holds command line configuration
 type Config struct {
-	Watch        bool
-	Force        bool
-	Collect      bool
-	BinDirs      []string
-	Everyone     bool
-	Src          string
-	Dst          string
-	ProcessUmask os.FileMode
+	Watch            bool
+	Poll             bool
+	DryRun           bool
+	Diff             bool
+	DiffFiltered     bool // Print the decoded content of filtered files in diffs
+	Force            bool
+	Collect          bool
+	BinDirs          []string
+	Everyone         bool
+	Owner            ownerSpec     // Owner of created destination paths when running as root
+	OwnerRules       []ownerRule   // Per-pattern owners, overriding Owner
+	KeepDirModes     []globPattern // Destination directories whose mode is not enforced
+	Links            bool          // Reproduce all source symlinks as symlinks
+	LinkPatterns     []globPattern // Source symlinks reproduced as symlinks when Links is off
+	Hooks            []hook
+	CommentRules     []commentRule   // Section marker styles per target file pattern
+	PlacementRules   []placementRule // Section placement per section file pattern
+	SectionChecksums bool            // Add a checksum of the section body to BEGIN markers
+	Filters          []contentFilter // Decode/encode commands for matching source files
+	GitIgnore        bool
+	DataFile         string // Variables for templates
+	BackupDir        string // Store for replaced and pruned destination files ("" disables backups)
+	BackupKeep       int    // Versions kept per destination file in the backup store (0 keeps all)
+	StateFile        string // State file set with -state or in the profile ("" selects one per source and destination)
+	Src              string
+	Dst              string
+	ProcessUmask     os.FileMode
+}
+
+// job is a single source-to-destination pass together with the caches that
+// persist across watch iterations.
+type job struct {
+	name          string
+	cfg           Config
+	stateFilePath string
+
+	// State cache variables to avoid re-parsing the state file if it hasn't changed.
+	cachedState     map[string]stateEntry
+	cachedStateMeta fileMeta
+
+	// metaCache stores metadata to detect changes in watch mode.
+	metaCache map[string]fileMeta
+}
+
+func newJob(name string, cfg Config) *job {
+	return &job{
+		name:          name,
+		cfg:           cfg,
+		stateFilePath: resolveStatePath(cfg),
+		metaCache:     make(map[string]fileMeta),
+	}
 }
 
 // fileMeta stores metadata for change detection
@@ -70,21 +115,31 @@ var (
 	// correcting any configuration drift caused by external processes.
 	// With a 4-second interval, this triggers a full scan roughly every 4 minutes.
 	fullScanIterations = 60
+
+	// fullScanInterval is the period of full scans when filesystem
+	// notifications replace polling. It matches the polling cadence above.
+	fullScanInterval = time.Duration(fullScanIterations) * watchRetryInterval
 )
 
 // Regex for detecting section files: e.g. "etc/fstab.external-disks-section"
 // Group 1: Target base path ("etc/fstab")
 // Group 2: Section name ("external-disks")
-var sectionFileRx = regexp.MustCompile(`^(.+)\.([^./]+)-section$`)
-
-// Regex for detecting section markers in content
-var (
-	beginSectionRx = regexp.MustCompile(`^# BEGIN (.+)$`)
-	endSectionRx   = regexp.MustCompile(`^# END (.+)$`)
-)
+// Group 3: Optional marker comment style, as in "app.conf.main-section.slash"
+var sectionFileRx = regexp.MustCompile(`^(.+)\.([^./]+)-section(?:\.(` + commentStyleNames("|") + `))?$`)
 
 func main() {
-	cfg := parseFlags()
+	command, args := splitCommand(os.Args[1:])
+
+	if command == "restore" {
+		runRestore(args)
+	}
+
+	var statusFormat *string
+	if command == "status" {
+		statusFormat = flag.String("format", "table", "Status output format: table or json.")
+	}
+
+	jobs := parseFlags(command, args)
 
 	// Create a context to handle graceful shutdown.
 	// This context is cancelled when a termination signal is received.
@@ -97,38 +152,117 @@ func main() {
 	// Initial validation: Source must exist and be a directory on startup.
 	// We only strictly require existence at start. Transient failures later
 	// (in watch mode) are handled in the loop.
-	if err := validateSource(cfg.Src); err != nil {
-		logger.Error("Error validating source", "err", err)
-		os.Exit(1)
+	for _, j := range jobs {
+		if err := validateSource(j.cfg.Src); err != nil {
+			logger.Error("Error validating source", "err", err)
+			os.Exit(1)
+		}
+		// Backups inside the source would be synced themselves
+		if j.cfg.BackupDir != "" && pathWithin(j.cfg.BackupDir, j.cfg.Src) {
+			logger.Error("Error: backup directory must not be inside the source directory", "backup-dir", j.cfg.BackupDir, "src", j.cfg.Src)
+			os.Exit(1)
+		}
+		if err := backupOverlaps(j.cfg); err != nil {
+			logger.Error("Error validating backup directory", "err", err)
+			os.Exit(1)
+		}
+		if pathWithin(j.stateFilePath, j.cfg.Src) {
+			logger.Error("Error: state file must not be inside the source directory", "state", j.stateFilePath, "src", j.cfg.Src)
+			os.Exit(1)
+		}
 	}
 
-	stateFilePath := filepath.Join(cfg.Src, ".etcdotica")
+	if !ownershipSupported() && slices.ContainsFunc(jobs, func(j *job) bool {
+		return j.cfg.Owner.Spec != "" || len(j.cfg.OwnerRules) > 0
+	}) {
+		logger.Warn("Ownership settings are ignored: only root can change file owners")
+	}
 
-	runLoop(ctx, cfg, stateFilePath)
+	if command == "status" {
+		runStatus(jobs, *statusFormat)
+	}
+
+	runLoop(ctx, jobs)
+}
+
+// splitCommand separates an optional leading subcommand from the flags.
+// Without a subcommand, the default "sync" command is assumed.
+func splitCommand(args []string) (string, []string) {
+	if len(args) > 0 && (args[0] == "status" || args[0] == "restore") {
+		return args[0], args[1:]
+	}
+	return "sync", args
 }
 
 // parseFlags handles command line argument parsing and configuration setup.
-func parseFlags() Config {
+// It returns the passes to run, one per profile mapping or a single pass built
+// from the command line.
+func parseFlags(command string, args []string) []*job {
 	defaultLogLevel := "info"
 	if env := os.Getenv("EDTC_LOG_LEVEL"); env != "" {
 		defaultLogLevel = env
 	}
 
+	backupDirFlag := flag.String("backup-dir", "", "Directory in which destination files are backed up before they are\noverwritten or pruned (default: /var/backups/etcdotica if root,\notherwise $XDG_STATE_HOME/etcdotica/backups).")
+
+	backupKeepFlag := flag.Int("backup-keep", 10, "Number of backups kept per destination file; older ones are removed\n(0 keeps all).")
+
 	var binDirs stringArray
 	flag.Var(&binDirs, "bindir", "Directory relative to the source directory in which all files will\nbe ensured to have the executable bit set (can be repeated).")
 
 	collectFlag := flag.Bool("collect", false, "Collect mode: copy newer files from destination back to source.\nIgnored if '-force' is enabled.")
+	var commentSpecs stringArray
+	flag.Var(&commentSpecs, "comment", "Comment style of section markers in target files matching a pattern,\ngiven as PATTERN=STYLE, where STYLE is one of "+commentStyleNames(", ")+"\n(can be repeated).")
+	dataFlag := flag.String("data", "", "TOML file with variables available to templates (*.tmpl) as .Vars.")
+	var decodeSpecs stringArray
+	flag.Var(&decodeSpecs, "decode", "Pipe source files matching a pattern through a shell command before\ncomparing and writing them, given as PATTERN=COMMAND, e.g.\n'*.age=age -d -i key.txt' (can be repeated).")
+	diffFlag := flag.Bool("diff", false, "Print a unified diff of every pending file and section change.\nCombine with '-dry-run' to review changes without applying them.")
+	diffFilteredFlag := flag.Bool("diff-filtered", false, "Print the decoded content of filtered files in '-diff' output instead\nof only noting that they differ.")
+	dryRunFlag := flag.Bool("dry-run", false, "Dry-run mode: print the actions a sync would perform without\nmodifying any files.")
 	dstFlag := flag.String("dst", "", "Destination directory (default: user home directory, or / if root).")
+	var encodeSpecs stringArray
+	flag.Var(&encodeSpecs, "encode", "Shell command that turns collected destination content back into\nsource content, given as PATTERN=COMMAND for a '-decode' pattern\n(can be repeated).")
 	everyoneFlag := flag.Bool("everyone", false, "Set group and other permissions to the same permission bits as\nthe owner, then apply the umask to the resulting mode.")
+	var keepDirModes stringArray
+	flag.Var(&keepDirModes, "keep-dir-mode", "Do not enforce the permissions and owner of destination directories\nmatching this pattern, e.g. shared ones (can be repeated).")
+	var hookSpecs stringArray
+	flag.Var(&hookSpecs, "hook", "Run a shell command after a change to matching destination paths,\ngiven as PATTERN=COMMAND (can be repeated).")
+
+	linksFlag := flag.Bool("links", false, "Reproduce symlinks in the source as symlinks at the destination\ninstead of copying what they point to.")
+	var linkPatterns stringArray
+	flag.Var(&linkPatterns, "link", "Reproduce source symlinks matching this pattern as symlinks\n(can be repeated).")
+	var mappingNames stringArray
+	flag.Var(&mappingNames, "mapping", "Run only the named profile mapping (can be repeated).")
+
+	ownerFlag := flag.String("owner", "", "When running as root, owner of destination files and directories:\ninherit (from the parent directory), user, user:group or :group.")
+	var placementSpecs stringArray
+	flag.Var(&placementSpecs, "placement", "Placement of sections from section files matching a pattern, given\nas PATTERN=PLACEMENT, where PLACEMENT is sorted (default), top,\nbottom, before:REGEX or after:REGEX (can be repeated).")
+	var ownerRuleSpecs stringArray
+	flag.Var(&ownerRuleSpecs, "owner-rule", "Owner for destination paths matching a pattern, given as\nPATTERN=OWNER (can be repeated). Overrides '-owner'.")
+	noBackupFlag := flag.Bool("no-backup", false, "Do not back up destination files before overwriting or pruning them.")
+	gitIgnoreFlag := flag.Bool("gitignore", false, "Also exclude source paths matched by .gitignore files.")
 	forceFlag := flag.Bool("force", false, "Force overwrite even if destination is newer. Overrides '-collect'.")
 	logFormat := flag.String("log-format", "human", "Log format: human, text or json")
 	logLevel := flag.String("log-level", defaultLogLevel, "Log level: debug, info, warn, error")
+	pollFlag := flag.Bool("poll", false, "In watch mode, poll the source periodically instead of using\nfilesystem notifications.")
+	profileFlag := flag.String("profile", "", "Profile file describing named source-to-destination mappings to\nrun in order (e.g. etcdotica.toml).")
+	sectionChecksumsFlag := flag.Bool("section-checksums", false, "Add a checksum of the section body to BEGIN markers, so sections\nedited in the target are detected and only overwritten with '-force'.")
 	srcFlag := flag.String("src", "", "Source directory (required).")
+	stateFlag := flag.String("state", "", "State file (default: one per source and destination in\n/var/lib/etcdotica/state if root, otherwise $XDG_STATE_HOME/etcdotica/state).")
 	umaskFlag := flag.String("umask", "", "Set process umask (octal, e.g. 077).")
 	versionFlag := flag.Bool("version", false, "Print version information and exit.")
 	watchFlag := flag.Bool("watch", false, "Watch mode: scan continuously for changes.")
 
-	flag.Parse()
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage: etcdotica [status] [options]\n       etcdotica restore PATH [-at TIME]\n\n")
+		fmt.Fprintf(out, "Commands:\n  status\treport drift of every managed path without changing anything\n")
+		fmt.Fprintf(out, "  restore\trestore a destination file from its backups (see 'etcdotica restore -help')\n\nOptions:\n")
+		flag.PrintDefaults()
+	}
+
+	// Errors are handled by flag.ExitOnError
+	_ = flag.CommandLine.Parse(args)
 
 	if *versionFlag {
 		fmt.Printf("etcdotica %s (%s)\n", Version, runtime.Version())
@@ -137,15 +271,101 @@ func parseFlags() Config {
 
 	setupLogger(*logFormat, *logLevel)
 
-	// Validation: src is required
-	if *srcFlag == "" {
+	// Validation: src is required unless the passes come from a profile
+	if *srcFlag == "" && *profileFlag == "" {
 		flag.Usage()
 		logger.Error("Error: -src argument is required")
 		os.Exit(1)
 	}
 
+	if *dryRunFlag && *watchFlag {
+		logger.Error("Error: -dry-run cannot be combined with -watch")
+		os.Exit(1)
+	}
+
+	if command == "status" && *watchFlag {
+		logger.Error("Error: the status command cannot be combined with -watch")
+		os.Exit(1)
+	}
+
+	if *backupKeepFlag < 0 {
+		logger.Error("Error: -backup-keep must not be negative")
+		os.Exit(1)
+	}
+
+	if *profileFlag == "" && len(mappingNames) > 0 {
+		logger.Error("Error: -mapping requires -profile")
+		os.Exit(1)
+	}
+
 	umask := setupUmask(*umaskFlag)
-	absSrc, absDst := resolvePaths(*srcFlag, *dstFlag)
+
+	var hooks []hook
+	for _, spec := range hookSpecs {
+		h, err := parseHookSpec(spec)
+		if err != nil {
+			logger.Error("Error parsing -hook", "err", err)
+			os.Exit(1)
+		}
+		hooks = append(hooks, h)
+	}
+
+	var commentRules []commentRule
+	for _, spec := range commentSpecs {
+		r, err := parseCommentRuleSpec(spec)
+		if err != nil {
+			logger.Error("Error parsing -comment", "err", err)
+			os.Exit(1)
+		}
+		commentRules = append(commentRules, r)
+	}
+
+	var placementRules []placementRule
+	for _, spec := range placementSpecs {
+		r, err := parsePlacementRuleSpec(spec)
+		if err != nil {
+			logger.Error("Error parsing -placement", "err", err)
+			os.Exit(1)
+		}
+		placementRules = append(placementRules, r)
+	}
+
+	filters, err := parseFilterSpecs(decodeSpecs, encodeSpecs)
+	if err != nil {
+		logger.Error("Error parsing -decode/-encode", "err", err)
+		os.Exit(1)
+	}
+
+	keepDirModePatterns, err := compileGlobs(keepDirModes)
+	if err != nil {
+		logger.Error("Error parsing -keep-dir-mode", "err", err)
+		os.Exit(1)
+	}
+
+	linkGlobs, err := compileGlobs(linkPatterns)
+	if err != nil {
+		logger.Error("Error parsing -link", "err", err)
+		os.Exit(1)
+	}
+
+	var owner ownerSpec
+	if *ownerFlag != "" {
+		var err error
+		if owner, err = parseOwnerSpec(*ownerFlag); err != nil {
+			logger.Error("Error parsing -owner", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	var ownerRules []ownerRule
+	for _, spec := range ownerRuleSpecs {
+		r, err := parseOwnerRuleSpec(spec)
+		if err != nil {
+			logger.Error("Error parsing -owner-rule", "err", err)
+			os.Exit(1)
+		}
+		ownerRules = append(ownerRules, r)
+	}
 
 	// Consolidate flags with Environment Variables.
 	// Force mode takes precedence over Collect mode. If Force is enabled, Collect
@@ -158,16 +378,93 @@ func parseFlags() Config {
 		logger.Warn("Both force and collect modes were enabled; force takes precedence and collect has been disabled.")
 	}
 
-	return Config{
-		Watch:        *watchFlag,
-		Force:        force,
-		Collect:      collect,
-		Src:          absSrc,
-		Dst:          absDst,
-		BinDirs:      binDirs,
-		Everyone:     *everyoneFlag,
-		ProcessUmask: umask,
+	cfg := Config{
+		Watch:            *watchFlag,
+		Poll:             *pollFlag,
+		DryRun:           *dryRunFlag,
+		Diff:             *diffFlag,
+		DiffFiltered:     *diffFilteredFlag,
+		Force:            force,
+		Collect:          collect,
+		GitIgnore:        *gitIgnoreFlag,
+		Hooks:            hooks,
+		Filters:          filters,
+		CommentRules:     commentRules,
+		PlacementRules:   placementRules,
+		SectionChecksums: *sectionChecksumsFlag,
+		Owner:            owner,
+		OwnerRules:       ownerRules,
+		KeepDirModes:     keepDirModePatterns,
+		Links:            *linksFlag,
+		LinkPatterns:     linkGlobs,
+		BackupDir:        resolveBackupDir(*backupDirFlag, *noBackupFlag),
+		BackupKeep:       *backupKeepFlag,
+	}
+
+	if *dataFlag != "" {
+		absData, err := filepath.Abs(*dataFlag)
+		if err != nil {
+			logger.Error("Error resolving data file path", "err", err)
+			os.Exit(1)
+		}
+		cfg.DataFile = absData
+	}
+
+	if *profileFlag != "" {
+		return profileJobs(*profileFlag, mappingNames, cfg, umask)
+	}
+
+	cfg.Src, cfg.Dst = resolvePaths(*srcFlag, *dstFlag)
+	if *stateFlag != "" {
+		absState, err := filepath.Abs(*stateFlag)
+		if err != nil {
+			logger.Error("Error resolving state file path", "err", err)
+			os.Exit(1)
+		}
+		cfg.StateFile = absState
 	}
+	cfg.BinDirs = binDirs
+	cfg.Everyone = *everyoneFlag
+	cfg.ProcessUmask = umask
+
+	return []*job{newJob("", cfg)}
+}
+
+// profileJobs loads the profile and builds one job per selected mapping.
+// Per-mapping settings cannot be combined with a profile on the command line.
+func profileJobs(path string, selected []string, base Config, umask os.FileMode) []*job {
+	var conflicting []string
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "src", "dst", "bindir", "umask", "everyone", "state":
+			conflicting = append(conflicting, "-"+f.Name)
+		}
+	})
+	if len(conflicting) > 0 {
+		logger.Error("Error: flags cannot be combined with -profile; set them per mapping instead", "flags", strings.Join(conflicting, " "))
+		os.Exit(1)
+	}
+
+	mappings, err := loadProfile(path, base, umask)
+	if err != nil {
+		logger.Error("Error loading profile", "err", err)
+		os.Exit(1)
+	}
+
+	var jobs []*job
+	for _, m := range mappings {
+		if len(selected) == 0 || slices.Contains(selected, m.Name) {
+			jobs = append(jobs, newJob(m.Name, m.Cfg))
+		}
+	}
+
+	for _, name := range selected {
+		if !slices.ContainsFunc(mappings, func(m mapping) bool { return m.Name == name }) {
+			logger.Error("Error: mapping not found in profile", "mapping", name, "profile", path)
+			os.Exit(1)
+		}
+	}
+	return jobs
 }
 
 // parseBoolEnv checks an environment variable for "1" or "true".
@@ -237,25 +534,52 @@ func getDefaultDest() string {
 }
 
 // runLoop executes the main synchronization loop.
-func runLoop(ctx context.Context, cfg Config, stateFilePath string) {
-	// Cache stores metadata to detect changes in watch mode.
-	metaCache := make(map[string]fileMeta)
-
-	// State cache variables to avoid re-parsing the state file if it hasn't changed.
-	// These persist across loop iterations.
-	var (
-		cachedState     map[string]struct{}
-		cachedStateMeta fileMeta
-	)
+// Each iteration runs every job in order; the exit status combines their results.
+// Watch-related settings are invocation-wide and therefore shared by all jobs.
+func runLoop(ctx context.Context, jobs []*job) {
+	watch := jobs[0].cfg.Watch
+
+	// In watch mode, prefer filesystem notifications over polling.
+	var watcher *changeWatcher
+	if watch && !jobs[0].cfg.Poll {
+		var err error
+		if watcher, err = newChangeWatcher(); err != nil {
+			logger.Info("Filesystem notifications unavailable; polling for changes", "err", err)
+		} else {
+			defer func() {
+				if watcher != nil {
+					watcher.close()
+				}
+			}()
+		}
+	}
 
 	// Iteration counter for periodic full scans.
 	var iterationCount int
+	lastFullScan := time.Now()
 
 	for {
 		// hasPartialErrors: Non-fatal errors occurred on specific files/sections (sync continued).
-		hasPartialErrors := syncIteration(cfg, stateFilePath, &cachedState, &cachedStateMeta, metaCache)
+		hasPartialErrors := false
 
-		if !cfg.Watch {
+		for _, j := range jobs {
+			if j.name != "" {
+				logger.Debug("Running mapping", "mapping", j.name, "src", j.cfg.Src, "dst", j.cfg.Dst)
+			}
+
+			// Mappings may use different umasks; newly created files and
+			// directories must honor the one belonging to the current pass.
+			applyUmask(j.cfg.ProcessUmask)
+
+			if syncIteration(j.cfg, j.stateFilePath, &j.cachedState, &j.cachedStateMeta, j.metaCache) {
+				if j.name != "" {
+					logger.Error("Mapping finished with partial errors", "mapping", j.name)
+				}
+				hasPartialErrors = true
+			}
+		}
+
+		if !watch {
 			if hasPartialErrors {
 				// Partial failure: Loop ran, but some files failed to sync.
 				logger.Error("Synchronization finished with partial errors")
@@ -270,6 +594,36 @@ func runLoop(ctx context.Context, cfg Config, stateFilePath string) {
 			logger.Error("Transient error in watch mode; retrying")
 		}
 
+		if watcher != nil {
+			// Watches are refreshed after every iteration to cover directories
+			// and managed files that appeared since the previous one.
+			if err := watcher.refresh(jobs); err != nil {
+				logger.Warn("Filesystem notifications exhausted; falling back to polling", "err", err)
+				watcher.close()
+				watcher = nil
+			}
+		}
+
+		if watcher != nil {
+			// Sleep until something changes. Transient errors are retried at the
+			// polling interval; otherwise we only wake up for the periodic full scan.
+			timeout := fullScanInterval - time.Since(lastFullScan)
+			if hasPartialErrors {
+				timeout = min(timeout, watchRetryInterval)
+			}
+
+			if !watcher.wait(ctx, max(timeout, 0)) {
+				logger.Info("Shutdown requested during wait. Exiting...")
+				return
+			}
+
+			if time.Since(lastFullScan) >= fullScanInterval {
+				clearMetaCaches(jobs)
+				lastFullScan = time.Now()
+			}
+			continue
+		}
+
 		// Wait logic: Sleep for the interval OR wake up immediately on shutdown signal.
 		select {
 		case <-ctx.Done():
@@ -282,25 +636,35 @@ func runLoop(ctx context.Context, cfg Config, stateFilePath string) {
 		// Increment counter and check if we should drop the cache.
 		iterationCount++
 		if iterationCount >= fullScanIterations {
-			// Dropping the cache forces the syncer to bypass the "source unchanged" optimization
-			// and strictly compare source vs destination metadata (mtime, size, perms).
-			// This detects and reverts external modifications to destination files.
-			logger.Debug("Clearing metadata cache for periodic full scan")
-			metaCache = make(map[string]fileMeta)
+			clearMetaCaches(jobs)
 			iterationCount = 0
 		}
 	}
 }
 
+// clearMetaCaches drops the metadata caches of all jobs for a periodic full scan.
+// Dropping the cache forces the syncer to bypass the "source unchanged" optimization
+// and strictly compare source vs destination metadata (mtime, size, perms).
+// This detects and reverts external modifications to destination files.
+func clearMetaCaches(jobs []*job) {
+	logger.Debug("Clearing metadata cache for periodic full scan")
+	for _, j := range jobs {
+		j.metaCache = make(map[string]fileMeta)
+	}
+}
+
 // syncIteration performs a single pass of synchronization.
 // Returns:
 //   - partialErrors: True if individual file/section errors occurred during the pass.
-func syncIteration(cfg Config, stateFilePath string, cachedState *map[string]struct{}, cachedStateMeta *fileMeta, metaCache map[string]fileMeta) bool {
+func syncIteration(cfg Config, stateFilePath string, cachedState *map[string]stateEntry, cachedStateMeta *fileMeta, metaCache map[string]fileMeta) bool {
+	if cfg.DryRun {
+		return planIteration(cfg, stateFilePath)
+	}
+
 	logger.Debug("Starting sync iteration")
 
 	// Open the state file with read/write permissions.
 	// We hold the file handle and lock throughout the entire sync process to prevent race conditions.
-	// If the source directory is transiently unavailable (e.g. network mount), this will fail.
 	stateFile, err := openAndLockState(stateFilePath)
 	if err != nil {
 		logger.Error("Error accessing state file", "err", err)
@@ -313,12 +677,34 @@ func syncIteration(cfg Config, stateFilePath string, cachedState *map[string]str
 
 	// Load previous state (handling cache hits)
 	currentState, err := loadStateWithCache(stateFile, cachedState, cachedStateMeta)
+	if errors.Is(err, errStateVersion) {
+		// Syncing with an empty state would overwrite the newer state file
+		logger.Error("Cannot read state file written by a newer release", "path", stateFilePath, "err", err)
+		return true
+	}
+	if errors.Is(err, errStateCorrupt) {
+		// Syncing with an empty state would forget every managed path
+		logger.Error("Cannot read state file; fix or remove it to continue", "path", stateFilePath, "err", err)
+		return true
+	}
 	if err != nil {
 		// If load fails (e.g. corruption), we assume empty state for THIS run.
 		// We log a warning so the user knows why pruning might be behaving as if the state is empty.
 		logger.Warn("Failed to parse state file, assuming empty state", "err", err)
 	}
 
+	// A state file left in the source directory by an earlier release is
+	// carried over while the state file at its new location is still empty.
+	legacyState, err := readLegacyState(stateFile, cfg.Src)
+	if err != nil {
+		logger.Error("Error reading state file in the source directory", "err", err)
+		return true
+	}
+	if legacyState != nil {
+		logger.Info("Migrating state file out of the source directory", "src", cfg.Src, "state", stateFilePath)
+		currentState = legacyState
+	}
+
 	// Ensure executable bits are set in specified bin directories before syncing
 	ensureExecBits(cfg.Src, cfg.BinDirs, cfg.ProcessUmask)
 
@@ -329,12 +715,36 @@ func syncIteration(cfg Config, stateFilePath string, cachedState *map[string]str
 	// Save State only if changes occurred.
 	// We do NOT update the cache here. If we wrote to the file, its mtime/size on disk has changed.
 	// On the next iteration, the check at the top of the loop will fail (mismatch), causing a fresh read.
-	if s.changed {
+	if s.changed || legacyState != nil {
+		s.stampState(time.Now())
 		if err := saveState(stateFile, s.newState); err != nil {
 			logger.Error("Error saving state", "err", err)
 			hasSyncErrors = true // Saving state is a critical part of the sync process
+		} else if legacyState != nil {
+			removeLegacyState(cfg.Src)
 		}
 	}
 
 	return hasSyncErrors
 }
+
+// planIteration performs a dry-run pass. It reads the state without creating
+// or modifying it, walks the source exactly like a real sync, and prints the
+// recorded actions to stdout.
+// Returns true if errors occurred while planning.
+func planIteration(cfg Config, stateFilePath string) bool {
+	logger.Debug("Starting dry-run iteration")
+
+	currentState, err := readStateSnapshot(stateFilePath, cfg.Src)
+	if err != nil {
+		logger.Warn("Failed to read state file, assuming empty state", "err", err)
+	}
+
+	s := newSyncer(cfg, currentState, make(map[string]fileMeta))
+	hasErrors := s.run()
+
+	printActions(os.Stdout, s.actions)
+	logger.Info("Dry run finished; no files were modified", "actions", len(s.actions))
+
+	return hasErrors
+}
@@ -0,0 +1,193 @@
+// Copyright 2025-2026 Stanislav Senotrusov
+//
+// This work is dual-licensed under the Apache License, Version 2.0 and the MIT License.
+// See LICENSE-APACHE and LICENSE-MIT in the top-level directory for details.
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ownerInherit is the owner spec that copies the ownership of the destination's parent directory.
+const ownerInherit = "inherit"
+
+// fileOwner is a numeric user and group; -1 leaves the respective id unchanged.
+type fileOwner struct {
+	uid, gid int
+}
+
+// noOwner leaves ownership unchanged.
+var noOwner = fileOwner{uid: -1, gid: -1}
+
+// set reports whether the owner changes anything.
+func (o fileOwner) set() bool {
+	return o.uid != -1 || o.gid != -1
+}
+
+// differs reports whether a file owned by current needs to be changed to o.
+func (o fileOwner) differs(current fileOwner) bool {
+	return (o.uid != -1 && o.uid != current.uid) || (o.gid != -1 && o.gid != current.gid)
+}
+
+// String formats the owner as user:group, preferring names over ids.
+func (o fileOwner) String() string {
+	name := func(id int, lookup func(string) (string, error)) string {
+		if id == -1 {
+			return ""
+		}
+		if n, err := lookup(strconv.Itoa(id)); err == nil {
+			return n
+		}
+		return strconv.Itoa(id)
+	}
+	u := name(o.uid, func(id string) (string, error) {
+		u, err := user.LookupId(id)
+		if err != nil {
+			return "", err
+		}
+		return u.Username, nil
+	})
+	g := name(o.gid, func(id string) (string, error) {
+		g, err := user.LookupGroupId(id)
+		if err != nil {
+			return "", err
+		}
+		return g.Name, nil
+	})
+	return u + ":" + g
+}
+
+// ownerSpec is a parsed ownership setting: "inherit", "user", "user:group" or ":group".
+type ownerSpec struct {
+	Spec    string
+	inherit bool
+	owner   fileOwner
+}
+
+// parseOwnerSpec resolves user and group names (or numeric ids) of a spec.
+func parseOwnerSpec(spec string) (ownerSpec, error) {
+	if spec == ownerInherit {
+		return ownerSpec{Spec: spec, inherit: true}, nil
+	}
+
+	userName, groupName, _ := strings.Cut(spec, ":")
+	if userName == "" && groupName == "" {
+		return ownerSpec{}, fmt.Errorf("invalid owner %q (expected inherit, user, user:group or :group)", spec)
+	}
+
+	owner := noOwner
+	if userName != "" {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			if u, err = user.LookupId(userName); err != nil {
+				return ownerSpec{}, fmt.Errorf("unknown user %q", userName)
+			}
+		}
+		if owner.uid, err = strconv.Atoi(u.Uid); err != nil {
+			return ownerSpec{}, fmt.Errorf("user %q has no numeric id", userName)
+		}
+		// "user" alone also sets the user's primary group, as chown(1) does with "user:"
+		if groupName == "" {
+			if owner.gid, err = strconv.Atoi(u.Gid); err != nil {
+				owner.gid = -1
+			}
+		}
+	}
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			if g, err = user.LookupGroupId(groupName); err != nil {
+				return ownerSpec{}, fmt.Errorf("unknown group %q", groupName)
+			}
+		}
+		if owner.gid, err = strconv.Atoi(g.Gid); err != nil {
+			return ownerSpec{}, fmt.Errorf("group %q has no numeric id", groupName)
+		}
+	}
+	return ownerSpec{Spec: spec, owner: owner}, nil
+}
+
+// ownerRule assigns an owner to destination paths matching a pattern.
+type ownerRule struct {
+	Pattern string // gitignore-style glob relative to the destination directory
+	Owner   ownerSpec
+	glob    globPattern
+}
+
+// newOwnerRule compiles an ownership rule.
+func newOwnerRule(pattern, spec string) (ownerRule, error) {
+	g, ok, err := compileGlob(pattern)
+	if err != nil {
+		return ownerRule{}, fmt.Errorf("owner pattern %q: %v", pattern, err)
+	}
+	if !ok || g.negate {
+		return ownerRule{}, fmt.Errorf("invalid owner pattern %q", pattern)
+	}
+	owner, err := parseOwnerSpec(spec)
+	if err != nil {
+		return ownerRule{}, err
+	}
+	return ownerRule{Pattern: pattern, Owner: owner, glob: g}, nil
+}
+
+// parseOwnerRuleSpec parses a "PATTERN=OWNER" command line value.
+func parseOwnerRuleSpec(spec string) (ownerRule, error) {
+	pattern, owner, ok := strings.Cut(spec, "=")
+	if !ok {
+		return ownerRule{}, fmt.Errorf("owner rule %q must have the form PATTERN=OWNER", spec)
+	}
+	return newOwnerRule(strings.TrimSpace(pattern), strings.TrimSpace(owner))
+}
+
+// applyOwner sets the ownership of a path the syncer created. Failures are
+// logged as partial errors; the path itself has been created successfully.
+func (s *syncer) applyOwner(path string, isDir bool) {
+	owner, err := s.ownerFor(path, isDir)
+	if err == nil && owner.set() {
+		err = os.Lchown(path, owner.uid, owner.gid)
+	}
+	if err != nil {
+		logger.Error("Failed to set owner", "path", path, "err", err)
+		s.hasErrors = true
+	}
+}
+
+// ownerFor returns the ownership a destination path should have. The last
+// matching rule wins, falling back to the mapping's owner. Ownership is only
+// managed when running as root; otherwise noOwner is returned.
+func (s *syncer) ownerFor(dstPath string, isDir bool) (fileOwner, error) {
+	if !ownershipSupported() || (s.cfg.Owner.Spec == "" && len(s.cfg.OwnerRules) == 0) {
+		return noOwner, nil
+	}
+
+	spec := s.cfg.Owner
+	if relPath, err := filepath.Rel(s.cfg.Dst, dstPath); err == nil {
+		relPath = filepath.ToSlash(relPath)
+		for _, r := range s.cfg.OwnerRules {
+			if r.glob.match(relPath, isDir) || r.glob.matchTree(relPath) {
+				spec = r.Owner
+			}
+		}
+	}
+
+	switch {
+	case spec.Spec == "":
+		return noOwner, nil
+	case spec.inherit:
+		owner, err := fileOwnerOf(filepath.Dir(dstPath))
+		if err != nil {
+			return noOwner, fmt.Errorf("reading owner of parent directory: %v", err)
+		}
+		return owner, nil
+	default:
+		return spec.owner, nil
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2025-2026 Stanislav Senotrusov
+//
+// This work is dual-licensed under the Apache License, Version 2.0 and the MIT License.
+// See LICENSE-APACHE and LICENSE-MIT in the top-level directory for details.
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Placement kinds of a section in its target file.
+const (
+	placeSorted = "sorted" // Ordered by name among the other sorted sections (default)
+	placeTop    = "top"    // At the beginning of the file
+	placeBottom = "bottom" // At the end of the file
+	placeBefore = "before" // Before the first unmanaged line matching a regex
+	placeAfter  = "after"  // After the first unmanaged line matching a regex
+)
+
+// sectionPlacement tells mergeBlocks where a section goes in its target file.
+type sectionPlacement struct {
+	Spec   string // As configured, e.g. "after:^auth"
+	kind   string
+	anchor *regexp.Regexp // For placeBefore and placeAfter
+
+	// Placement kinds of the other sections of the target file that are not
+	// sorted. Sorted sections are ordered among themselves and leave these alone.
+	pinned map[string]string
+}
+
+// defaultPlacement keeps sections in name order.
+var defaultPlacement = sectionPlacement{Spec: placeSorted, kind: placeSorted}
+
+// parsePlacement parses "sorted", "top", "bottom", "before:REGEX" or "after:REGEX".
+func parsePlacement(spec string) (sectionPlacement, error) {
+	kind, expr, hasAnchor := strings.Cut(spec, ":")
+	switch kind {
+	case placeSorted, placeTop, placeBottom:
+		if hasAnchor {
+			return sectionPlacement{}, fmt.Errorf("placement %q takes no anchor", kind)
+		}
+		return sectionPlacement{Spec: spec, kind: kind}, nil
+	case placeBefore, placeAfter:
+		if expr == "" {
+			return sectionPlacement{}, fmt.Errorf("placement %q needs an anchor, e.g. %s:^auth", kind, kind)
+		}
+		rx, err := regexp.Compile(expr)
+		if err != nil {
+			return sectionPlacement{}, fmt.Errorf("placement anchor %q: %v", expr, err)
+		}
+		return sectionPlacement{Spec: spec, kind: kind, anchor: rx}, nil
+	}
+	return sectionPlacement{}, fmt.Errorf("invalid placement %q (expected sorted, top, bottom, before:REGEX or after:REGEX)", spec)
+}
+
+// placementRule assigns a placement to section files matching a pattern.
+type placementRule struct {
+	Pattern   string // gitignore-style glob relative to the source directory
+	Placement sectionPlacement
+	glob      globPattern
+}
+
+// newPlacementRule compiles a placement rule.
+func newPlacementRule(pattern, spec string) (placementRule, error) {
+	g, ok, err := compileGlob(pattern)
+	if err != nil {
+		return placementRule{}, fmt.Errorf("placement pattern %q: %v", pattern, err)
+	}
+	if !ok || g.negate {
+		return placementRule{}, fmt.Errorf("invalid placement pattern %q", pattern)
+	}
+	p, err := parsePlacement(spec)
+	if err != nil {
+		return placementRule{}, err
+	}
+	return placementRule{Pattern: pattern, Placement: p, glob: g}, nil
+}
+
+// parsePlacementRuleSpec parses a "PATTERN=PLACEMENT" command line value.
+// Only the first "=" separates the two, so anchors may contain "=".
+func parsePlacementRuleSpec(spec string) (placementRule, error) {
+	pattern, placement, ok := strings.Cut(spec, "=")
+	if !ok {
+		return placementRule{}, fmt.Errorf("placement rule %q must have the form PATTERN=PLACEMENT", spec)
+	}
+	return newPlacementRule(strings.TrimSpace(pattern), strings.TrimSpace(placement))
+}
+
+// placementOf returns the configured placement of a section file.
+// The last matching rule wins.
+func (s *syncer) placementOf(relPath string) sectionPlacement {
+	relPath = filepath.ToSlash(alternateBase(relPath))
+	place := defaultPlacement
+	for _, r := range s.cfg.PlacementRules {
+		if r.glob.match(relPath, false) || r.glob.matchTree(relPath) {
+			place = r.Placement
+		}
+	}
+	return place
+}
+
+// sectionPlacement returns the placement of a section source entry, together
+// with the placements of the other sections merged into the same target. Those
+// are the section files next to it, since a target's sections share its directory.
+func (s *syncer) sectionPlacement(relPath string) sectionPlacement {
+	place := s.placementOf(relPath)
+	if len(s.cfg.PlacementRules) == 0 {
+		return place
+	}
+	match := sectionFileRx.FindStringSubmatch(alternateBase(relPath))
+	if match == nil {
+		return place
+	}
+
+	entries, err := os.ReadDir(filepath.Join(s.cfg.Src, filepath.Dir(relPath)))
+	if err != nil {
+		return place // The walk reports unreadable directories
+	}
+	place.pinned = make(map[string]string)
+	for _, e := range entries {
+		sibling := filepath.Join(filepath.Dir(relPath), e.Name())
+		m := sectionFileRx.FindStringSubmatch(alternateBase(sibling))
+		if e.IsDir() || m == nil || m[1] != match[1] || m[2] == match[2] {
+			continue
+		}
+		if kind := s.placementOf(sibling).kind; kind != placeSorted {
+			place.pinned[m[2]] = kind
+		}
+	}
+	return place
+}
+
+// sectionPriorityRx matches the numeric priority prefix of a section name, as in "10-faillock".
+var sectionPriorityRx = regexp.MustCompile(`^([0-9]+)-`)
+
+// sectionLess orders section names. Names with a numeric priority prefix come
+// first, ordered by number and then by name; all other names follow in
+// alphabetical order.
+func sectionLess(a, b string) bool {
+	pa, okA := sectionPriority(a)
+	pb, okB := sectionPriority(b)
+	switch {
+	case okA != okB:
+		return okA
+	case okA && pa != pb:
+		return pa < pb
+	}
+	return a < b
+}
+
+// sectionPriority returns the numeric priority prefix of a section name.
+func sectionPriority(name string) (uint64, bool) {
+	match := sectionPriorityRx.FindStringSubmatch(name)
+	if match == nil {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(match[1], 10, 64)
+	return n, err == nil
+}
@@ -0,0 +1,249 @@
+// Copyright 2025-2026 Stanislav Senotrusov
+//
+// This work is dual-licensed under the Apache License, Version 2.0 and the MIT License.
+// See LICENSE-APACHE and LICENSE-MIT in the top-level directory for details.
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// actionKind identifies a filesystem change that a sync pass intends to make.
+type actionKind int
+
+const (
+	actionMkdir actionKind = iota
+	actionCreate
+	actionUpdate
+	actionChmod
+	actionChown
+	actionTouch
+	actionCollect
+	actionSkipNewer
+	actionSkipEdited
+	actionMergeSection
+	actionRemoveSection
+	actionMergeFragment
+	actionRemoveFragment
+	actionPrune
+	actionHook
+)
+
+func (k actionKind) String() string {
+	switch k {
+	case actionMkdir:
+		return "mkdir"
+	case actionCreate:
+		return "create"
+	case actionUpdate:
+		return "update"
+	case actionChmod:
+		return "chmod"
+	case actionChown:
+		return "chown"
+	case actionTouch:
+		return "touch"
+	case actionCollect:
+		return "collect"
+	case actionSkipNewer:
+		return "skip-newer"
+	case actionSkipEdited:
+		return "skip-edited"
+	case actionMergeSection:
+		return "merge-section"
+	case actionRemoveSection:
+		return "remove-section"
+	case actionMergeFragment:
+		return "merge-fragment"
+	case actionRemoveFragment:
+		return "remove-fragment"
+	case actionPrune:
+		return "prune"
+	case actionHook:
+		return "hook"
+	default:
+		return "unknown"
+	}
+}
+
+// action is a single intended change recorded in dry-run mode.
+type action struct {
+	Kind   actionKind
+	Entry  string // State entry (source-relative path) the action belongs to
+	Path   string // Destination path affected by the action, or the command of a hook
+	Detail string // Optional human-readable detail (e.g. mode change)
+}
+
+// record appends an intended action to the plan.
+// Planned destination changes are also noted for hooks, so the plan lists
+// the hooks a real run would trigger.
+func (s *syncer) record(kind actionKind, entry, path, detail string) {
+	s.actions = append(s.actions, action{Kind: kind, Entry: entry, Path: path, Detail: detail})
+
+	switch kind {
+	case actionCreate, actionUpdate, actionChmod, actionChown, actionTouch, actionMergeSection, actionRemoveSection, actionMergeFragment, actionRemoveFragment, actionPrune:
+		s.touched(path)
+	}
+}
+
+// printActions writes the recorded plan in a human-readable, one-action-per-line form.
+func printActions(w io.Writer, actions []action) {
+	for _, a := range actions {
+		line := fmt.Sprintf("%-14s %s", a.Kind, a.Path)
+		if a.Detail != "" {
+			line += " (" + a.Detail + ")"
+		}
+		fmt.Fprintln(w, line)
+	}
+}
+
+// planFile records how syncFile would change the destination without touching it.
+// It distinguishes a new file, a content update, and metadata-only changes.
+func (s *syncer) planFile(relPath string, src sourceFile, dstPath string, perm os.FileMode, owner fileOwner) error {
+	dstInfo, err := os.Lstat(dstPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			s.record(actionCreate, relPath, dstPath, fmt.Sprintf("mode %04o", perm))
+			return nil
+		}
+		return err
+	}
+
+	if dstInfo.Mode()&os.ModeSymlink != 0 {
+		s.record(actionUpdate, relPath, dstPath, "replace symlink")
+		return nil
+	}
+
+	same, err := src.equalTo(dstPath, dstInfo.Size())
+	if err != nil {
+		return err
+	}
+
+	currentOwner := noOwner
+	if owner.set() {
+		if currentOwner, err = fileOwnerOf(dstPath); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case !same:
+		s.record(actionUpdate, relPath, dstPath, "")
+	case dstInfo.Mode().Perm() != perm:
+		s.record(actionChmod, relPath, dstPath, fmt.Sprintf("%04o -> %04o", dstInfo.Mode().Perm(), perm))
+	case owner.differs(currentOwner):
+		s.record(actionChown, relPath, dstPath, fmt.Sprintf("%s -> %s", currentOwner, owner))
+	default:
+		s.record(actionTouch, relPath, dstPath, "mtime only")
+	}
+	return nil
+}
+
+// planSection records whether mergeSection would modify the target file.
+func (s *syncer) planSection(relPath, srcPath, dstPath, sectionName string, style commentStyle, place sectionPlacement, srcInfo os.FileInfo) error {
+	oldContent, newContent, err := previewMergeSection(srcPath, dstPath, sectionName, style, place, s.cfg.SectionChecksums)
+	if err != nil {
+		return err
+	}
+
+	expectedPerms := calculatePerms(srcInfo.Mode(), s.cfg.ProcessUmask, s.cfg.Everyone)
+
+	dstInfo, statErr := os.Stat(dstPath)
+	switch {
+	case os.IsNotExist(statErr):
+		s.record(actionMergeSection, relPath, dstPath, fmt.Sprintf("section %s, new file", sectionName))
+	case statErr != nil:
+		return statErr
+	case !bytes.Equal(oldContent, newContent):
+		s.record(actionMergeSection, relPath, dstPath, "section "+sectionName)
+	case dstInfo.Mode().Perm() != expectedPerms:
+		s.record(actionChmod, relPath, dstPath, fmt.Sprintf("%04o -> %04o", dstInfo.Mode().Perm(), expectedPerms))
+	}
+	return nil
+}
+
+// planRemoveSection records whether removeSection would modify the target file.
+func (s *syncer) planRemoveSection(relPath, dstPath, sectionName string, style commentStyle) error {
+	oldContent, newContent, err := previewRemoveSection(dstPath, sectionName, style)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(oldContent, newContent) {
+		s.record(actionRemoveSection, relPath, dstPath, "section "+sectionName)
+	}
+	return nil
+}
+
+// planFragment records whether mergeFragment would modify the target file.
+func (s *syncer) planFragment(format *fragmentFormat, relPath, srcPath, dstPath, name string, keys []mergedKey, srcInfo os.FileInfo) error {
+	oldContent, newContent, err := previewMergeFragment(format, srcPath, dstPath, keys)
+	if err != nil {
+		return err
+	}
+
+	expectedPerms := calculatePerms(srcInfo.Mode(), s.cfg.ProcessUmask, s.cfg.Everyone)
+
+	dstInfo, statErr := os.Stat(dstPath)
+	switch {
+	case os.IsNotExist(statErr):
+		s.record(actionMergeFragment, relPath, dstPath, fmt.Sprintf("%s fragment %s, new file", format.Name, name))
+	case statErr != nil:
+		return statErr
+	case !bytes.Equal(oldContent, newContent):
+		s.record(actionMergeFragment, relPath, dstPath, format.Name+" fragment "+name)
+	case dstInfo.Mode().Perm() != expectedPerms:
+		s.record(actionChmod, relPath, dstPath, fmt.Sprintf("%04o -> %04o", dstInfo.Mode().Perm(), expectedPerms))
+	}
+	return nil
+}
+
+// planRemoveFragment records whether removeFragment would modify the target file.
+func (s *syncer) planRemoveFragment(format *fragmentFormat, relPath, dstPath, name string, keys []mergedKey) error {
+	oldContent, newContent, err := previewRemoveFragment(format, dstPath, keys)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(oldContent, newContent) {
+		s.record(actionRemoveFragment, relPath, dstPath, format.Name+" fragment "+name)
+	}
+	return nil
+}
+
+// planPrune records the removal of an orphaned destination file if it still exists.
+func (s *syncer) planPrune(relPath, dstPath string) error {
+	if _, err := os.Lstat(dstPath); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	s.record(actionPrune, relPath, dstPath, "")
+	return nil
+}
+
+// filesEqual compares two files by size and then byte-by-byte.
+func filesEqual(path1, path2 string, size1, size2 int64) (bool, error) {
+	if size1 != size2 {
+		return false, nil
+	}
+
+	f1, err := os.Open(path1)
+	if err != nil {
+		return false, err
+	}
+	defer f1.Close()
+
+	f2, err := os.Open(path2)
+	if err != nil {
+		return false, err
+	}
+	defer f2.Close()
+
+	return contentsEqual(f1, f2)
+}
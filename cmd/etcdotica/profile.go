@@ -0,0 +1,360 @@
+// Copyright 2025-2026 Stanislav Senotrusov
+//
+// This work is dual-licensed under the Apache License, Version 2.0 and the MIT License.
+// See LICENSE-APACHE and LICENSE-MIT in the top-level directory for details.
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// mapping is a named source-to-destination pass defined in a profile file.
+type mapping struct {
+	Name string
+	Cfg  Config
+}
+
+// loadProfile reads a profile file and returns its mappings in file order.
+// Every top-level table of the profile is a mapping. Each mapping starts from
+// base, which carries the command line settings shared by all passes, and
+// uses defaultUmask unless it sets its own.
+// Relative paths in the profile are resolved against the profile's directory.
+func loadProfile(path string, base Config, defaultUmask os.FileMode) ([]mapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := parseToml(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("parsing profile %s: %v", path, err)
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	baseDir := filepath.Dir(absPath)
+
+	var mappings []mapping
+	for _, name := range root.keys {
+		table, ok := root.values[name].(*tomlTable)
+		if !ok {
+			return nil, fmt.Errorf("profile %s: top-level key %q must be a mapping table", path, name)
+		}
+		cfg, err := decodeMapping(table, base, defaultUmask, baseDir)
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: mapping %q: %v", path, name, err)
+		}
+		mappings = append(mappings, mapping{Name: name, Cfg: cfg})
+	}
+
+	if len(mappings) == 0 {
+		return nil, fmt.Errorf("profile %s defines no mappings", path)
+	}
+	return mappings, nil
+}
+
+// decodeMapping converts a mapping table into a Config.
+func decodeMapping(t *tomlTable, base Config, defaultUmask os.FileMode, baseDir string) (Config, error) {
+	cfg := base
+	cfg.ProcessUmask = defaultUmask
+	var src, dst string
+	var force, collect bool
+
+	for _, key := range t.keys {
+		value := t.values[key]
+		var err error
+
+		switch key {
+		case "src":
+			src, err = tomlString(key, value)
+		case "dst":
+			dst, err = tomlString(key, value)
+		case "bindir":
+			cfg.BinDirs, err = tomlStrings(key, value)
+		case "everyone":
+			cfg.Everyone, err = tomlBool(key, value)
+		case "comments":
+			cfg.CommentRules, err = decodeCommentRules(value, base.CommentRules)
+		case "data":
+			var dataFile string
+			if dataFile, err = tomlString(key, value); err == nil {
+				cfg.DataFile = resolveProfilePath(baseDir, dataFile)
+			}
+		case "filters":
+			cfg.Filters, err = decodeFilters(value, base.Filters)
+		case "gitignore":
+			cfg.GitIgnore, err = tomlBool(key, value)
+		case "hooks":
+			cfg.Hooks, err = decodeHooks(value, base.Hooks)
+		case "keep-dir-mode":
+			var patterns []string
+			if patterns, err = tomlStrings(key, value); err == nil {
+				var globs []globPattern
+				if globs, err = compileGlobs(patterns); err == nil {
+					cfg.KeepDirModes = append(slices.Clone(base.KeepDirModes), globs...)
+				}
+			}
+		case "links":
+			// Either true/false for all symlinks, or patterns selecting some of them
+			if b, ok := value.(bool); ok {
+				cfg.Links = b
+				break
+			}
+			var patterns []string
+			if patterns, err = tomlStrings(key, value); err != nil {
+				err = fmt.Errorf("links must be a boolean, a string or an array of strings")
+				break
+			}
+			var globs []globPattern
+			if globs, err = compileGlobs(patterns); err == nil {
+				cfg.LinkPatterns = append(slices.Clone(base.LinkPatterns), globs...)
+			}
+		case "owner":
+			var spec string
+			if spec, err = tomlString(key, value); err == nil {
+				cfg.Owner, err = parseOwnerSpec(spec)
+			}
+		case "owners":
+			cfg.OwnerRules, err = decodeOwnerRules(value, base.OwnerRules)
+		case "placement":
+			cfg.PlacementRules, err = decodePlacementRules(value, base.PlacementRules)
+		case "state":
+			var stateFile string
+			if stateFile, err = tomlString(key, value); err == nil {
+				cfg.StateFile = resolveProfilePath(baseDir, stateFile)
+			}
+		case "section-checksums":
+			cfg.SectionChecksums, err = tomlBool(key, value)
+		case "force":
+			force, err = tomlBool(key, value)
+		case "collect":
+			collect, err = tomlBool(key, value)
+		case "umask":
+			var umaskStr string
+			if umaskStr, err = tomlString(key, value); err == nil {
+				var val uint64
+				if val, err = strconv.ParseUint(umaskStr, 8, 32); err != nil {
+					err = fmt.Errorf("invalid umask %q: %v", umaskStr, err)
+				}
+				cfg.ProcessUmask = os.FileMode(val)
+			}
+		default:
+			err = fmt.Errorf("unknown key %q", key)
+		}
+
+		if err != nil {
+			return Config{}, err
+		}
+	}
+
+	if src == "" {
+		return Config{}, fmt.Errorf("src is required")
+	}
+
+	// Force takes precedence over collect, as on the command line.
+	cfg.Force = base.Force || force
+	cfg.Collect = (base.Collect || collect) && !cfg.Force
+	if cfg.Force && collect {
+		logger.Warn("Mapping enables collect but force is in effect; collect has been disabled", "src", src)
+	}
+
+	cfg.Src = resolveProfilePath(baseDir, src)
+	if dst != "" {
+		cfg.Dst = resolveProfilePath(baseDir, dst)
+	} else {
+		cfg.Dst = getDefaultDest()
+	}
+
+	if cfg.Src == cfg.Dst {
+		return Config{}, fmt.Errorf("source and destination directories are the same: %s", cfg.Src)
+	}
+	return cfg, nil
+}
+
+// decodeHooks converts a hooks table (pattern = command) into hooks.
+// Hooks from the command line run first, followed by those of the table in file order.
+func decodeHooks(value any, base []hook) ([]hook, error) {
+	table, ok := value.(*tomlTable)
+	if !ok {
+		return nil, fmt.Errorf("hooks must be a table of pattern = command")
+	}
+	hooks := slices.Clone(base)
+	for _, pattern := range table.keys {
+		command, err := tomlString("hook "+pattern, table.values[pattern])
+		if err != nil {
+			return nil, err
+		}
+		h, err := newHook(pattern, command)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, nil
+}
+
+// decodeCommentRules converts a comments table (pattern = style) into marker style rules.
+// Rules from the command line come first, so those of the table take precedence.
+func decodeCommentRules(value any, base []commentRule) ([]commentRule, error) {
+	table, ok := value.(*tomlTable)
+	if !ok {
+		return nil, fmt.Errorf("comments must be a table of pattern = style")
+	}
+	rules := slices.Clone(base)
+	for _, pattern := range table.keys {
+		style, err := tomlString("comment style of "+pattern, table.values[pattern])
+		if err != nil {
+			return nil, err
+		}
+		r, err := newCommentRule(pattern, style)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// decodePlacementRules converts a placement table (pattern = placement) into section placement rules.
+// Rules from the command line come first, so those of the table take precedence.
+func decodePlacementRules(value any, base []placementRule) ([]placementRule, error) {
+	table, ok := value.(*tomlTable)
+	if !ok {
+		return nil, fmt.Errorf("placement must be a table of pattern = placement")
+	}
+	rules := slices.Clone(base)
+	for _, pattern := range table.keys {
+		spec, err := tomlString("placement of "+pattern, table.values[pattern])
+		if err != nil {
+			return nil, err
+		}
+		r, err := newPlacementRule(pattern, spec)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// decodeFilters converts a filters table, whose subtables are keyed by pattern
+// and hold decode and (optionally) encode commands, into content filters.
+// Filters from the command line come first, so those of the table take precedence.
+func decodeFilters(value any, base []contentFilter) ([]contentFilter, error) {
+	table, ok := value.(*tomlTable)
+	if !ok {
+		return nil, fmt.Errorf("filters must be a table of per-pattern tables with decode and encode commands")
+	}
+	filters := slices.Clone(base)
+	for _, pattern := range table.keys {
+		def, ok := table.values[pattern].(*tomlTable)
+		if !ok {
+			return nil, fmt.Errorf("filter %s must be a table with decode and encode commands", pattern)
+		}
+		var decode, encode string
+		for _, key := range def.keys {
+			var err error
+			switch key {
+			case "decode":
+				decode, err = tomlString("decode command of "+pattern, def.values[key])
+			case "encode":
+				encode, err = tomlString("encode command of "+pattern, def.values[key])
+			default:
+				err = fmt.Errorf("unknown key %q in filter %s", key, pattern)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		f, err := newContentFilter(pattern, decode, encode)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	return filters, nil
+}
+
+// decodeOwnerRules converts an owners table (pattern = owner) into ownership rules.
+// Rules from the command line come first, so those of the table take precedence.
+func decodeOwnerRules(value any, base []ownerRule) ([]ownerRule, error) {
+	table, ok := value.(*tomlTable)
+	if !ok {
+		return nil, fmt.Errorf("owners must be a table of pattern = owner")
+	}
+	rules := slices.Clone(base)
+	for _, pattern := range table.keys {
+		spec, err := tomlString("owner of "+pattern, table.values[pattern])
+		if err != nil {
+			return nil, err
+		}
+		r, err := newOwnerRule(pattern, spec)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// resolveProfilePath expands a leading "~" to the user's home directory and
+// makes relative paths absolute with respect to baseDir.
+func resolveProfilePath(baseDir, path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[1:])
+		}
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(baseDir, path)
+	}
+	return filepath.Clean(path)
+}
+
+// tomlString asserts that a profile value is a string.
+func tomlString(key string, value any) (string, error) {
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("%s must be a string", key)
+	}
+	return s, nil
+}
+
+// tomlStrings asserts that a profile value is a string or an array of strings.
+func tomlStrings(key string, value any) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s must be an array of strings", key)
+			}
+			out = append(out, s)
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("%s must be a string or an array of strings", key)
+	}
+}
+
+// tomlBool asserts that a profile value is a boolean.
+func tomlBool(key string, value any) (bool, error) {
+	b, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s must be a boolean", key)
+	}
+	return b, nil
+}
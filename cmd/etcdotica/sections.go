@@ -9,29 +9,35 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
+	"slices"
 )
 
 // chunk represents a part of the file, either raw text or a named section.
 type chunk struct {
 	isSection bool
 	name      string // empty if raw text
+	checksum  string // Checksum carried by the BEGIN marker, if any
 	lines     []string
 }
 
 // mergeSection reads the source section file and merges it into the target file.
 // It respects the alphabetical ordering of sections and safety checks for broken tags.
-func mergeSection(srcPath, dstPath, sectionName string, srcInfo os.FileInfo, umask os.FileMode, everyone bool) (bool, error) {
+// With checksum, the BEGIN marker carries a checksum of the section body.
+// It also returns the digest of the merged section body (see sectionDigest).
+func mergeSection(srcPath, dstPath, sectionName string, style commentStyle, place sectionPlacement, checksum bool, srcInfo os.FileInfo, umask os.FileMode, everyone bool) (bool, string, error) {
 	srcLines, err := readLines(srcPath)
 	if err != nil {
-		return false, err
+		return false, "", err
 	}
 
 	// Check for directory conflict at destination.
 	if info, err := os.Stat(dstPath); err == nil && info.IsDir() {
-		return false, fmt.Errorf("conflict: target %s is a directory", dstPath)
+		return false, "", fmt.Errorf("conflict: target %s is a directory", dstPath)
 	}
 
 	// Determine Expected Permissions
@@ -41,27 +47,27 @@ func mergeSection(srcPath, dstPath, sectionName string, srcInfo os.FileInfo, uma
 	// Open Destination File (Read/Write, Create if missing)
 	f, err := os.OpenFile(dstPath, os.O_RDWR|os.O_CREATE, expectedPerms)
 	if err != nil {
-		return false, err
+		return false, "", err
 	}
 	defer f.Close()
 
 	if err := lockFile(f.Fd(), true); err != nil {
-		return false, err
+		return false, "", err
 	}
 
 	content, err := io.ReadAll(f)
 	if err != nil {
-		return false, err
+		return false, "", err
 	}
 
-	newBytes, changed, err := computeMergedContent(content, srcLines, sectionName)
+	newBytes, changed, err := computeMergedContent(content, srcLines, sectionName, style, place, checksum)
 	if err != nil {
-		return false, err
+		return false, "", err
 	}
 
 	if changed {
 		if err := writeContent(f, newBytes); err != nil {
-			return false, err
+			return false, "", err
 		}
 	}
 
@@ -72,14 +78,30 @@ func mergeSection(srcPath, dstPath, sectionName string, srcInfo os.FileInfo, uma
 		logger.Warn("Failed to chmod", "path", dstPath, "err", err)
 	}
 
-	return changed, nil
+	return changed, sectionDigest(srcLines), nil
+}
+
+// sectionDigest returns the digest of a section body that is recorded in the
+// state, so that later edits to the section in the target can be detected.
+func sectionDigest(lines []string) string {
+	return "sha256:" + sectionHash(lines)
+}
+
+// sectionHash returns the hex-encoded SHA-256 hash of a section body.
+func sectionHash(lines []string) string {
+	h := sha256.New()
+	for _, line := range lines {
+		io.WriteString(h, line)
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil))
 }
 
 // computeMergedContent parses existing content and merges the new section.
-func computeMergedContent(oldContent []byte, srcLines []string, sectionName string) ([]byte, bool, error) {
+func computeMergedContent(oldContent []byte, srcLines []string, sectionName string, style commentStyle, place sectionPlacement, checksum bool) ([]byte, bool, error) {
 	oldLines := splitLines(oldContent)
 
-	blocks, err := parseBlocks(oldLines, sectionName)
+	blocks, err := parseBlocks(oldLines, sectionName, style)
 	if err != nil {
 		return nil, false, err
 	}
@@ -87,33 +109,56 @@ func computeMergedContent(oldContent []byte, srcLines []string, sectionName stri
 	newChunk := chunk{
 		isSection: true,
 		name:      sectionName,
-		lines:     wrapSection(srcLines, sectionName),
+		lines:     wrapSection(srcLines, sectionName, style, checksum),
 	}
 
-	newBlocks := mergeBlocks(blocks, newChunk, sectionName)
+	newBlocks := mergeBlocks(blocks, newChunk, sectionName, place)
 	newBytes := serializeBlocks(newBlocks)
 
 	return newBytes, !bytes.Equal(oldContent, newBytes), nil
 }
 
-func wrapSection(lines []string, name string) []string {
+// wrapSection surrounds the section lines with BEGIN and END markers.
+// With checksum, the BEGIN marker also carries the hash of the lines, so
+// later edits of the section in the target can be told apart.
+func wrapSection(lines []string, name string, style commentStyle, checksum bool) []string {
+	begin := style.marker("BEGIN", name)
+	if checksum {
+		begin = style.marker("BEGIN", name+" "+markerChecksumPrefix+sectionHash(lines))
+	}
 	res := make([]string, 0, len(lines)+2)
-	res = append(res, fmt.Sprintf("# BEGIN %s", name))
+	res = append(res, begin)
 	res = append(res, lines...)
-	res = append(res, fmt.Sprintf("# END %s", name))
+	res = append(res, style.marker("END", name))
 	return res
 }
 
 // mergeBlocks inserts the new chunk into the correct position.
-func mergeBlocks(blocks []chunk, newChunk chunk, sectionName string) []chunk {
+// Sorted sections take their place by name among the other sorted sections;
+// other placements only apply when the section is inserted, and an existing
+// section is replaced where it is.
+func mergeBlocks(blocks []chunk, newChunk chunk, sectionName string, place sectionPlacement) []chunk {
+	if place.kind != placeSorted {
+		for i, b := range blocks {
+			if b.isSection && b.name == sectionName {
+				out, _ := dropSection(blocks[i+1:], sectionName)
+				return append(append(append([]chunk{}, blocks[:i]...), newChunk), out...)
+			}
+		}
+		if out, ok := placeBlocks(blocks, newChunk, place); ok {
+			return out
+		}
+		// An anchor that matches nothing falls back to the default order
+	}
+
 	var out []chunk
 	inserted := false
 
 	// Strategy:
 	// Iterate through existing blocks.
 	// If we find our section -> Replace it.
-	// If we find a section strictly GREATER than ours -> Insert before it.
-	// If raw -> Keep.
+	// If we find a sorted section that sorts AFTER ours (see sectionLess) -> Insert before it.
+	// If raw, or a section with an explicit placement -> Keep.
 
 	for _, b := range blocks {
 		if inserted {
@@ -125,12 +170,12 @@ func mergeBlocks(blocks []chunk, newChunk chunk, sectionName string) []chunk {
 			continue
 		}
 
-		if b.isSection {
+		if b.isSection && place.pinned[b.name] == "" {
 			if b.name == sectionName {
 				out = append(out, newChunk) // Replace
 				inserted = true
-			} else if sectionName < b.name {
-				// Found a section that comes alphabetically AFTER ours.
+			} else if sectionLess(sectionName, b.name) {
+				// Found a section that comes AFTER ours.
 				// We must insert ours BEFORE this one.
 				out = append(out, newChunk)
 				out = append(out, b)
@@ -145,12 +190,71 @@ func mergeBlocks(blocks []chunk, newChunk chunk, sectionName string) []chunk {
 		}
 	}
 	if !inserted {
-		// If we reached the end without inserting, append to the end
-		out = append(out, newChunk)
+		// If we reached the end without inserting, append to the end,
+		// but above the sections placed at the bottom
+		i := len(out)
+		for i > 0 && out[i-1].isSection && place.pinned[out[i-1].name] == placeBottom {
+			i--
+		}
+		out = append(out[:i], append([]chunk{newChunk}, out[i:]...)...)
 	}
 	return out
 }
 
+// placeBlocks inserts a section that is not in the target yet according to
+// an explicit placement. Sections placed at the top or bottom keep name order
+// among the other sections placed there. It returns false if an anchor
+// matches no unmanaged line.
+func placeBlocks(blocks []chunk, newChunk chunk, place sectionPlacement) ([]chunk, bool) {
+	replaceBlock := func(i int, parts ...chunk) []chunk {
+		out := append([]chunk{}, blocks[:i]...)
+		out = append(out, parts...)
+		return append(out, blocks[i+1:]...)
+	}
+
+	switch place.kind {
+	case placeTop:
+		i := 0
+		for i < len(blocks) && blocks[i].isSection && place.pinned[blocks[i].name] == placeTop && sectionLess(blocks[i].name, newChunk.name) {
+			i++
+		}
+		return append(append(append([]chunk{}, blocks[:i]...), newChunk), blocks[i:]...), true
+
+	case placeBottom:
+		i := len(blocks)
+		for i > 0 && blocks[i-1].isSection && place.pinned[blocks[i-1].name] == placeBottom && sectionLess(newChunk.name, blocks[i-1].name) {
+			i--
+		}
+		return append(append(append([]chunk{}, blocks[:i]...), newChunk), blocks[i:]...), true
+	}
+
+	// Anchored: split the unmanaged block holding the first matching line
+	for i, b := range blocks {
+		if b.isSection {
+			continue
+		}
+		for j, line := range b.lines {
+			if !place.anchor.MatchString(line) {
+				continue
+			}
+			split := j
+			if place.kind == placeAfter {
+				split = j + 1
+			}
+			var parts []chunk
+			if split > 0 {
+				parts = append(parts, chunk{lines: b.lines[:split]})
+			}
+			parts = append(parts, newChunk)
+			if split < len(b.lines) {
+				parts = append(parts, chunk{lines: b.lines[split:]})
+			}
+			return replaceBlock(i, parts...), true
+		}
+	}
+	return nil, false
+}
+
 // serializeBlocks joins chunks back into bytes.
 func serializeBlocks(blocks []chunk) []byte {
 	var buf bytes.Buffer
@@ -164,7 +268,7 @@ func serializeBlocks(blocks []chunk) []byte {
 }
 
 // removeSection removes the named section from the target file.
-func removeSection(dstPath, sectionName string) (bool, error) {
+func removeSection(dstPath, sectionName string, style commentStyle) (bool, error) {
 	f, err := os.OpenFile(dstPath, os.O_RDWR, 0666)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -185,35 +289,129 @@ func removeSection(dstPath, sectionName string) (bool, error) {
 
 	oldLines := splitLines(content)
 
-	blocks, err := parseBlocks(oldLines, sectionName)
+	blocks, err := parseBlocks(oldLines, sectionName, style)
 	if err != nil {
 		return false, fmt.Errorf("parsing target file: %v", err)
 	}
 
-	// Filter out the section
-	var newBlocks []chunk
+	newBlocks, found := dropSection(blocks, sectionName)
+	if !found {
+		return false, nil
+	}
+
+	return true, writeContent(f, serializeBlocks(newBlocks))
+}
+
+// dropSection filters the named section out of the blocks.
+// It reports whether the section was present.
+func dropSection(blocks []chunk, sectionName string) ([]chunk, bool) {
+	var out []chunk
 	found := false
 	for _, b := range blocks {
 		if b.isSection && b.name == sectionName {
 			found = true
 			continue
 		}
-		newBlocks = append(newBlocks, b)
+		out = append(out, b)
+	}
+	return out, found
+}
+
+// previewMergeSection returns the current target content and the content
+// mergeSection would produce, without modifying the target file.
+// A missing target is treated as empty.
+func previewMergeSection(srcPath, dstPath, sectionName string, style commentStyle, place sectionPlacement, checksum bool) ([]byte, []byte, error) {
+	srcLines, err := readLines(srcPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if info, err := os.Stat(dstPath); err == nil && info.IsDir() {
+		return nil, nil, fmt.Errorf("conflict: target %s is a directory", dstPath)
+	}
+
+	content, err := readLockedShared(dstPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	newBytes, _, err := computeMergedContent(content, srcLines, sectionName, style, place, checksum)
+	if err != nil {
+		return nil, nil, err
+	}
+	return content, newBytes, nil
+}
+
+// previewRemoveSection returns the current target content and the content
+// removeSection would produce, without modifying the target file.
+func previewRemoveSection(dstPath, sectionName string, style commentStyle) ([]byte, []byte, error) {
+	content, err := readLockedShared(dstPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	blocks, err := parseBlocks(splitLines(content), sectionName, style)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing target file: %v", err)
 	}
 
+	newBlocks, found := dropSection(blocks, sectionName)
 	if !found {
-		return false, nil
+		return content, content, nil
 	}
+	return content, serializeBlocks(newBlocks), nil
+}
 
-	return true, writeContent(f, serializeBlocks(newBlocks))
+// extractSection returns the lines between the markers of the named section
+// in the target file. found is false if the target or the section is missing.
+func extractSection(dstPath, sectionName string, style commentStyle) (lines []string, found bool, err error) {
+	content, err := readLockedShared(dstPath)
+	if err != nil {
+		return nil, false, err
+	}
+
+	blocks, err := parseBlocks(splitLines(content), sectionName, style)
+	if err != nil {
+		return nil, false, fmt.Errorf("parsing target file: %v", err)
+	}
+	for _, b := range blocks {
+		if b.isSection && b.name == sectionName {
+			return b.lines[1 : len(b.lines)-1], true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// sectionEdited reports whether the BEGIN marker of the named section in the
+// target file carries a checksum that the section body no longer matches,
+// i.e. the section was edited by hand since it was merged. A body equal to
+// srcLines, such as one collected into the source, is not an edit: the merge
+// only has to refresh the checksum.
+func sectionEdited(dstPath, sectionName string, style commentStyle, srcLines []string) (bool, error) {
+	content, err := readLockedShared(dstPath)
+	if err != nil {
+		return false, err
+	}
+
+	blocks, err := parseBlocks(splitLines(content), sectionName, style)
+	if err != nil {
+		return false, fmt.Errorf("parsing target file: %v", err)
+	}
+	for _, b := range blocks {
+		if b.isSection && b.name == sectionName && b.checksum != "" {
+			body := b.lines[1 : len(b.lines)-1]
+			return b.checksum != sectionHash(body) && !slices.Equal(body, srcLines), nil
+		}
+	}
+	return false, nil
 }
 
 // parseBlocks reads lines and groups them into chunks (Raw vs Named Sections).
 // It validates that if the specific targetSectionName is present, it is well-formed.
 // Other malformed sections are treated as raw text to avoid destruction.
-func parseBlocks(lines []string, targetSectionName string) ([]chunk, error) {
+func parseBlocks(lines []string, targetSectionName string, style commentStyle) ([]chunk, error) {
 	var blocks []chunk
-	validSections, err := findValidSections(lines, targetSectionName)
+	validSections, err := findValidSections(lines, targetSectionName, style)
 	if err != nil {
 		return nil, err
 	}
@@ -226,7 +424,7 @@ func parseBlocks(lines []string, targetSectionName string) ([]chunk, error) {
 			blocks = append(blocks, chunk{isSection: false, lines: lines[lineIdx:sec.start]})
 		}
 		// Add the section
-		blocks = append(blocks, chunk{isSection: true, name: sec.name, lines: lines[sec.start : sec.end+1]})
+		blocks = append(blocks, chunk{isSection: true, name: sec.name, checksum: sec.checksum, lines: lines[sec.start : sec.end+1]})
 		lineIdx = sec.end + 1
 	}
 
@@ -240,29 +438,42 @@ func parseBlocks(lines []string, targetSectionName string) ([]chunk, error) {
 type span struct {
 	start, end int
 	name       string
+	checksum   string
 }
 
-// findValidSections scans lines for valid BEGIN/END pairs.
+// findValidSections scans lines for valid BEGIN/END pairs, in the style of the
+// target file or in the hash style of earlier releases (see markerStyles). A
+// BEGIN marker must be closed by an END marker in the same style.
 // CRITICAL: It returns an error if the target section has malformed tags (orphaned begin or end).
 // This prevents us from corrupting a file where the user might have manually edited the section tags.
-func findValidSections(lines []string, targetName string) ([]span, error) {
+func findValidSections(lines []string, targetName string, style commentStyle) ([]span, error) {
 	var sections []span
+	styles := style.markerStyles()
 
 	for i := 0; i < len(lines); i++ {
-		match := beginSectionRx.FindStringSubmatch(lines[i])
-		if match == nil {
+		var name, checksum string
+		var markerStyle commentStyle
+		ok := false
+		for _, c := range styles {
+			if name, checksum, ok = c.parseBegin(lines[i]); ok {
+				markerStyle = c
+				break
+			}
+		}
+		if !ok {
 			// Check for orphaned END tags of target
-			if endMatch := endSectionRx.FindStringSubmatch(lines[i]); endMatch != nil && endMatch[1] == targetName {
-				return nil, fmt.Errorf("found orphaned closing tag for section '%s' at line %d", targetName, i+1)
+			for _, c := range styles {
+				if endName, ok := c.parseMarker(lines[i], "END"); ok && endName == targetName {
+					return nil, fmt.Errorf("found orphaned closing tag for section '%s' at line %d", targetName, i+1)
+				}
 			}
 			continue
 		}
 
-		name := match[1]
-		endIdx := findEndTag(lines, i+1, name)
+		endIdx := findEndTag(lines, i+1, name, markerStyle)
 
 		if endIdx != -1 {
-			sections = append(sections, span{i, endIdx, name})
+			sections = append(sections, span{i, endIdx, name, checksum})
 			i = endIdx // Advance outer loop
 		} else {
 			// Opening tag without closing tag
@@ -277,14 +488,13 @@ func findValidSections(lines []string, targetName string) ([]span, error) {
 
 // findEndTag looks ahead for the matching END tag.
 // It stops if it finds a nested BEGIN tag for the same name (which is considered broken/raw).
-func findEndTag(lines []string, startIdx int, name string) int {
+func findEndTag(lines []string, startIdx int, name string, style commentStyle) int {
 	for j := startIdx; j < len(lines); j++ {
-		endMatch := endSectionRx.FindStringSubmatch(lines[j])
-		if endMatch != nil && endMatch[1] == name {
+		if endName, ok := style.parseMarker(lines[j], "END"); ok && endName == name {
 			return j
 		}
 		// Nested/Duplicate begin check
-		if beginMatch := beginSectionRx.FindStringSubmatch(lines[j]); beginMatch != nil && beginMatch[1] == name {
+		if beginName, _, ok := style.parseBegin(lines[j]); ok && beginName == name {
 			break
 		}
 	}
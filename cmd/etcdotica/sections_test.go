@@ -0,0 +1,374 @@
+// Copyright 2025-2026 Stanislav Senotrusov
+//
+// This work is dual-licensed under the Apache License, Version 2.0 and the MIT License.
+// See LICENSE-APACHE and LICENSE-MIT in the top-level directory for details.
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// hashStyle is the default "# BEGIN name" marker style.
+var hashStyle = commentStyles[0]
+
+// section returns the lines of a hash-style section with the given body.
+func section(name string, body ...string) string {
+	return string(joinLines(wrapSection(body, name, hashStyle, false)))
+}
+
+// placement parses a placement spec; pinned gives the placements of other
+// sections of the target, as "name=kind" pairs.
+func placement(t *testing.T, spec string, pinned ...string) sectionPlacement {
+	t.Helper()
+	place, err := parsePlacement(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	place.pinned = make(map[string]string)
+	for _, p := range pinned {
+		name, kind, _ := strings.Cut(p, "=")
+		place.pinned[name] = kind
+	}
+	return place
+}
+
+func TestComputeMergedContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		old     string
+		section string
+		place   string
+		pinned  []string
+		want    string
+	}{
+		{
+			name:    "empty target",
+			old:     "",
+			section: "b",
+			want:    section("b", "new"),
+		},
+		{
+			name:    "sorted between sections, keeping raw text",
+			old:     "raw\n" + section("a", "1") + "middle\n" + section("c", "3") + "tail\n",
+			section: "b",
+			want:    "raw\n" + section("a", "1") + "middle\n" + section("b", "new") + section("c", "3") + "tail\n",
+		},
+		{
+			name:    "sorted after every section",
+			old:     section("a", "1") + "tail\n",
+			section: "b",
+			want:    section("a", "1") + "tail\n" + section("b", "new"),
+		},
+		{
+			name:    "numeric priority sorts first",
+			old:     section("2-x", "1") + section("a", "1"),
+			section: "10-y",
+			want:    section("2-x", "1") + section("10-y", "new") + section("a", "1"),
+		},
+		{
+			name:    "replaced in place",
+			old:     "head\n" + section("b", "old", "lines") + "tail\n",
+			section: "b",
+			want:    "head\n" + section("b", "new") + "tail\n",
+		},
+		{
+			name:    "sorting skips sections with a placement",
+			old:     section("c", "1") + section("z", "9"),
+			section: "b",
+			pinned:  []string{"c=after"},
+			want:    section("c", "1") + section("b", "new") + section("z", "9"),
+		},
+		{
+			name:    "appended above sections pinned to the bottom",
+			old:     "raw\n" + section("a", "1"),
+			section: "b",
+			pinned:  []string{"a=bottom"},
+			want:    "raw\n" + section("b", "new") + section("a", "1"),
+		},
+		{
+			name:    "top",
+			old:     "raw\n" + section("a", "1"),
+			section: "z",
+			place:   "top",
+			want:    section("z", "new") + "raw\n" + section("a", "1"),
+		},
+		{
+			name:    "top keeps name order among top sections",
+			old:     section("a", "1") + section("c", "3") + "raw\n",
+			section: "b",
+			place:   "top",
+			pinned:  []string{"a=top", "c=top"},
+			want:    section("a", "1") + section("b", "new") + section("c", "3") + "raw\n",
+		},
+		{
+			name:    "bottom",
+			old:     section("z", "9") + "raw\n",
+			section: "a",
+			place:   "bottom",
+			want:    section("z", "9") + "raw\n" + section("a", "new"),
+		},
+		{
+			name:    "before anchor",
+			old:     "one\nauth x\ntwo\n",
+			section: "s",
+			place:   "before:^auth",
+			want:    "one\n" + section("s", "new") + "auth x\ntwo\n",
+		},
+		{
+			name:    "after anchor",
+			old:     "one\nauth x\ntwo\n",
+			section: "s",
+			place:   "after:^auth",
+			want:    "one\nauth x\n" + section("s", "new") + "two\n",
+		},
+		{
+			name:    "anchor is not matched inside sections",
+			old:     section("a", "auth y") + "auth x\n",
+			section: "s",
+			place:   "after:^auth",
+			want:    section("a", "auth y") + "auth x\n" + section("s", "new"),
+		},
+		{
+			name:    "unmatched anchor falls back to name order",
+			old:     "one\n" + section("z", "9"),
+			section: "s",
+			place:   "after:^auth",
+			want:    "one\n" + section("s", "new") + section("z", "9"),
+		},
+		{
+			name:    "existing section with a placement stays where it is",
+			old:     "raw\n" + section("s", "old") + "tail\n",
+			section: "s",
+			place:   "top",
+			want:    "raw\n" + section("s", "new") + "tail\n",
+		},
+		{
+			name:    "malformed other section is raw text",
+			old:     "# BEGIN other\nx\n",
+			section: "s",
+			want:    "# BEGIN other\nx\n" + section("s", "new"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := tt.place
+			if spec == "" {
+				spec = placeSorted
+			}
+			place := placement(t, spec, tt.pinned...)
+
+			got, changed, err := computeMergedContent([]byte(tt.old), []string{"new"}, tt.section, hashStyle, place, false)
+			if err != nil {
+				t.Fatalf("computeMergedContent: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+			if changed != (tt.old != tt.want) {
+				t.Errorf("changed = %v, want %v", changed, tt.old != tt.want)
+			}
+
+			// Merging again is a no-op
+			again, changed, err := computeMergedContent(got, []string{"new"}, tt.section, hashStyle, place, false)
+			if err != nil {
+				t.Fatalf("second merge: %v", err)
+			}
+			if changed || string(again) != tt.want {
+				t.Errorf("second merge changed the content:\n%s", again)
+			}
+		})
+	}
+}
+
+func TestComputeMergedContentChecksum(t *testing.T) {
+	got, _, err := computeMergedContent(nil, []string{"x"}, "s", hashStyle, defaultPlacement, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# BEGIN s " + markerChecksumPrefix + sectionHash([]string{"x"}) + "\nx\n# END s\n"
+	if string(got) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	blocks, err := parseBlocks(splitLines(got), "s", hashStyle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 1 || blocks[0].name != "s" || blocks[0].checksum != sectionHash([]string{"x"}) {
+		t.Errorf("checksum not parsed back: %+v", blocks)
+	}
+}
+
+func TestSectionEdited(t *testing.T) {
+	merged, _, err := computeMergedContent(nil, []string{"x"}, "s", hashStyle, defaultPlacement, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		content  string
+		srcLines []string
+		want     bool
+	}{
+		{"untouched", string(merged), []string{"x"}, false},
+		{"edited", strings.Replace(string(merged), "\nx\n", "\ny\n", 1), []string{"x"}, true},
+		{"edited to the source", strings.Replace(string(merged), "\nx\n", "\ny\n", 1), []string{"y"}, false},
+		{"without checksum", section("s", "y"), []string{"x"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "target")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := sectionEdited(path, "s", hashStyle, tt.srcLines)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("sectionEdited = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComputeMergedContentBrokenTags(t *testing.T) {
+	tests := []struct {
+		name string
+		old  string
+		want string
+	}{
+		{"orphaned end", "x\n# END s\n", "orphaned closing tag"},
+		{"missing end", "# BEGIN s\nx\n", "no closing tag"},
+		{"nested begin", "# BEGIN s\n# BEGIN s\n# END s\n", "no closing tag"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := computeMergedContent([]byte(tt.old), []string{"new"}, "s", hashStyle, defaultPlacement, false)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDropSection(t *testing.T) {
+	old := "head\n" + section("a", "1") + section("b", "2") + "tail\n"
+	blocks, err := parseBlocks(splitLines([]byte(old)), "a", hashStyle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, found := dropSection(blocks, "a")
+	if !found {
+		t.Fatal("section not found")
+	}
+	if got, want := string(serializeBlocks(out)), "head\n"+section("b", "2")+"tail\n"; got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if _, found := dropSection(out, "a"); found {
+		t.Error("section found after it was dropped")
+	}
+}
+
+func TestComputeMergedContentStyles(t *testing.T) {
+	xml, err := lookupCommentStyle("xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	xmlSection := func(name string, body ...string) string {
+		return string(joinLines(wrapSection(body, name, xml, false)))
+	}
+
+	tests := []struct {
+		name    string
+		old     string
+		section string
+		want    string
+	}{
+		{
+			name:    "new section uses the file's style",
+			old:     "<p>\n",
+			section: "s",
+			want:    "<p>\n" + xmlSection("s", "new"),
+		},
+		{
+			name:    "hash section is rewritten in the file's style",
+			old:     "<p>\n" + section("s", "old") + "</p>\n",
+			section: "s",
+			want:    "<p>\n" + xmlSection("s", "new") + "</p>\n",
+		},
+		{
+			name:    "hash sections keep their order",
+			old:     section("a", "1") + section("c", "3"),
+			section: "b",
+			want:    section("a", "1") + xmlSection("b", "new") + section("c", "3"),
+		},
+		{
+			name:    "markers must be closed in the same style",
+			old:     "# BEGIN a\n<!-- END a -->\n",
+			section: "b",
+			want:    "# BEGIN a\n<!-- END a -->\n" + xmlSection("b", "new"),
+		},
+		{
+			name:    "other styles are raw text",
+			old:     "// BEGIN s\nx\n// END s\n",
+			section: "s",
+			want:    "// BEGIN s\nx\n// END s\n" + xmlSection("s", "new"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := computeMergedContent([]byte(tt.old), []string{"new"}, tt.section, xml, defaultPlacement, false)
+			if err != nil {
+				t.Fatalf("computeMergedContent: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+
+	// An orphaned hash marker of the target section is still an error
+	if _, _, err := computeMergedContent([]byte("# END s\n"), []string{"new"}, "s", xml, defaultPlacement, false); err == nil {
+		t.Error("orphaned hash marker: merge succeeded, want an error")
+	}
+
+	// A hash section is removed from a file of another style
+	blocks, err := parseBlocks(splitLines([]byte("<p>\n"+section("s", "old"))), "s", xml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, found := dropSection(blocks, "s"); !found || string(serializeBlocks(out)) != "<p>\n" {
+		t.Errorf("hash section not removed: %q", serializeBlocks(out))
+	}
+}
+
+func TestSectionFileStyleSuffix(t *testing.T) {
+	tests := []struct {
+		path    string
+		section string
+		style   string
+	}{
+		{"etc/fstab.disks-section", "disks", ""},
+		{"php.ini.opcache-section.semicolon", "opcache", "semicolon"},
+		{"app.conf.logging-section.xml", "logging", "xml"},
+		{"notes.todo-section.txt", "", ""},
+		{"a.b-section.XML", "", ""},
+	}
+	for _, tt := range tests {
+		match := sectionFileRx.FindStringSubmatch(tt.path)
+		switch {
+		case tt.section == "" && match != nil:
+			t.Errorf("%s: matched as section %q", tt.path, match[2])
+		case tt.section != "" && (match == nil || match[2] != tt.section || match[3] != tt.style):
+			t.Errorf("%s: got %q, want section %q with style %q", tt.path, match, tt.section, tt.style)
+		}
+	}
+}
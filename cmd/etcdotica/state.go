@@ -9,15 +9,135 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"os/user"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// stateVersion is the version of the state file format written by saveState.
+// Files without a header use the format of earlier releases, one path per line.
+const stateVersion = 1
+
+// stateFormat identifies the header of a state file.
+const stateFormat = "etcdotica-state"
+
+// Kinds of state entries.
+const (
+	kindFile     = "file"     // Regular file or template, copied to its destination
+	kindLink     = "link"     // Symlink preserved as a link
+	kindDir      = "dir"      // Directory created by etcdotica
+	kindSection  = "section"  // Section merged into a target file
+	kindFragment = "fragment" // Merge fragment or line file
+)
+
+// stateEntry is what the state file records about a managed source entry.
+type stateEntry struct {
+	Kind    string      // One of the kind constants
+	Dest    string      // Destination path the entry manages
+	Digest  string      // Content last written; for sections, the section body last merged
+	Mode    os.FileMode // Permissions of the destination when last applied
+	ModTime time.Time   // Modification time of the destination when last applied
+	Applied time.Time   // When the entry was last applied; zero if not known
+	Keys    []mergedKey // Keys contributed to the target; merge fragment entries only
+}
+
+// mergedKey is a key that a merge fragment set in its target file.
+type mergedKey struct {
+	Path  []string `json:"path"`            // Key path, e.g. object keys or section and key
+	Prior *string  `json:"prior,omitempty"` // Line the key replaced, restored when the fragment is removed
+}
+
+// stateHeader is the first line of a state file.
+type stateHeader struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+}
+
+// stateRecord is a state entry as written to the state file, one JSON object per line.
+type stateRecord struct {
+	Src     string      `json:"src"`
+	Kind    string      `json:"kind,omitempty"`
+	Dest    string      `json:"dest,omitempty"`
+	Digest  string      `json:"digest,omitempty"`
+	Mode    string      `json:"mode,omitempty"` // Octal, e.g. "0644"
+	ModTime time.Time   `json:"mtime,omitzero"`
+	Applied time.Time   `json:"applied,omitzero"`
+	Keys    []mergedKey `json:"keys,omitempty"`
+}
+
+// legacyStateFile is the name of the state file that earlier releases kept in
+// the source directory. It is migrated to the state directory on the first sync.
+const legacyStateFile = ".etcdotica"
+
+// defaultStateDir returns the directory holding the state files of every
+// source and destination pair: /var/lib/etcdotica/state for root, and
+// $XDG_STATE_HOME/etcdotica/state (~/.local/state by default) for other users.
+func defaultStateDir() (string, error) {
+	currentUser, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	if currentUser.Uid == "0" {
+		return "/var/lib/etcdotica/state", nil
+	}
+	return filepath.Join(xdgStateHome(currentUser), "etcdotica", "state"), nil
+}
+
+// resolveStatePath returns the state file of a job: the one set with -state
+// or in the profile, or else one in the default state directory named after
+// a hash of the source and destination, so that applying a source to several
+// destinations keeps their states apart.
+func resolveStatePath(cfg Config) string {
+	if cfg.StateFile != "" {
+		return cfg.StateFile
+	}
+	dir, err := defaultStateDir()
+	if err != nil {
+		logger.Error("Error determining default state directory", "err", err)
+		os.Exit(1)
+	}
+	sum := sha256.Sum256([]byte(cfg.Src + "\x00" + cfg.Dst))
+	return filepath.Join(dir, hex.EncodeToString(sum[:16]))
+}
+
+// errStateVersion reports a state file written by a newer release.
+var errStateVersion = errors.New("unsupported state file version")
+
+// errStateCorrupt reports a state file in the current format that cannot be read.
+var errStateCorrupt = errors.New("corrupted state file")
+
+// entryKind returns the kind of a state entry, as far as it follows from its
+// path. Preserved symlinks cannot be told from files by name.
+func entryKind(relPath string) string {
+	base := alternateBase(relPath)
+	switch {
+	case isDirEntry(relPath):
+		return kindDir
+	case sectionFileRx.MatchString(base):
+		return kindSection
+	case isFragment(base):
+		return kindFragment
+	}
+	return kindFile
+}
+
 // openAndLockState opens the state file and acquires an exclusive lock.
+// The state directory is created if missing.
 func openAndLockState(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
 	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
 	if err != nil {
 		return nil, err
@@ -30,12 +150,68 @@ func openAndLockState(path string) (*os.File, error) {
 	return f, nil
 }
 
+// readStateSnapshot reads the state file under a shared lock without creating it.
+// If it is missing, the state file of an earlier release in the source
+// directory src is read instead, as a sync would migrate it. If neither
+// exists, the state is empty.
+func readStateSnapshot(path, src string) (map[string]stateEntry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) && src != "" {
+		return readStateSnapshot(filepath.Join(src, legacyStateFile), "")
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			return make(map[string]stateEntry), nil
+		}
+		return make(map[string]stateEntry), err
+	}
+	defer f.Close()
+
+	if err := lockFile(f.Fd(), false); err != nil {
+		return make(map[string]stateEntry), fmt.Errorf("locking state file: %v", err)
+	}
+
+	state, err := loadState(f)
+	if err != nil {
+		return make(map[string]stateEntry), err
+	}
+	return state, nil
+}
+
+// readLegacyState returns the state of an earlier release kept in the source
+// directory src, if the locked state file f is still empty. It returns nil if
+// there is nothing to migrate.
+func readLegacyState(f *os.File, src string) (map[string]stateEntry, error) {
+	info, err := f.Stat()
+	if err != nil || info.Size() > 0 {
+		return nil, err
+	}
+	legacyPath := filepath.Join(src, legacyStateFile)
+	if _, err := os.Stat(legacyPath); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return readStateSnapshot(legacyPath, "")
+}
+
+// removeLegacyState deletes the state file of an earlier release from the
+// source directory once its state has been saved to the new location.
+// A read-only source keeps it; it is no longer read once the new state exists.
+func removeLegacyState(src string) {
+	legacyPath := filepath.Join(src, legacyStateFile)
+	if err := os.Remove(legacyPath); err != nil && !os.IsNotExist(err) {
+		logger.Warn("Failed to remove migrated state file from the source directory", "path", legacyPath, "err", err)
+	}
+}
+
 // loadStateWithCache loads the state, using cached values if the file hasn't changed.
-func loadStateWithCache(f *os.File, cachedState *map[string]struct{}, cachedMeta *fileMeta) (map[string]struct{}, error) {
+func loadStateWithCache(f *os.File, cachedState *map[string]stateEntry, cachedMeta *fileMeta) (map[string]stateEntry, error) {
 	info, statErr := f.Stat()
 	if statErr != nil {
 		*cachedState = nil
-		return make(map[string]struct{}), statErr
+		return make(map[string]stateEntry), statErr
 	}
 
 	// We check `cachedState != nil` to ensure we don't use an empty cache on the very first run.
@@ -58,7 +234,7 @@ func loadStateWithCache(f *os.File, cachedState *map[string]struct{}, cachedMeta
 	} else {
 		// If Load failed, we can't reliably cache this result.
 		*cachedState = nil
-		state = make(map[string]struct{}) // Return empty state on failure so logic proceeds
+		state = make(map[string]stateEntry) // Return empty state on failure so logic proceeds
 	}
 
 	return state, err
@@ -66,21 +242,74 @@ func loadStateWithCache(f *os.File, cachedState *map[string]struct{}, cachedMeta
 
 // loadState reads the state from the provided reader.
 // It expects the caller to handle file opening and locking.
-func loadState(r io.Reader) (map[string]struct{}, error) {
-	state := make(map[string]struct{})
+// The file starts with a header line, followed by one JSON object per entry.
+// Files that do not start with a JSON object are read in the format of
+// earlier releases and are rewritten in the current format when the state is
+// next saved.
+func loadState(r io.Reader) (map[string]stateEntry, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return make(map[string]stateEntry), err
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return loadLegacyState(bytes.NewReader(data))
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var header stateHeader
+	if err := dec.Decode(&header); err != nil {
+		return make(map[string]stateEntry), fmt.Errorf("%w: header: %v", errStateCorrupt, err)
+	}
+	if header.Format != stateFormat {
+		return make(map[string]stateEntry), fmt.Errorf("%w: unknown format %q", errStateCorrupt, header.Format)
+	}
+	if header.Version > stateVersion {
+		return make(map[string]stateEntry), fmt.Errorf("%w %d", errStateVersion, header.Version)
+	}
+
+	state := make(map[string]stateEntry)
+	for dec.More() {
+		var rec stateRecord
+		if err := dec.Decode(&rec); err != nil {
+			return state, fmt.Errorf("%w: %v", errStateCorrupt, err)
+		}
+		entry := stateEntry{
+			Kind:    rec.Kind,
+			Dest:    rec.Dest,
+			Digest:  rec.Digest,
+			ModTime: rec.ModTime,
+			Applied: rec.Applied,
+			Keys:    rec.Keys,
+		}
+		if rec.Mode != "" {
+			mode, err := strconv.ParseUint(rec.Mode, 8, 32)
+			if err != nil {
+				return state, fmt.Errorf("%w: entry %s: invalid mode %q", errStateCorrupt, rec.Src, rec.Mode)
+			}
+			entry.Mode = os.FileMode(mode)
+		}
+		state[rec.Src] = entry
+	}
+	return state, nil
+}
+
+// loadLegacyState reads the format of earlier releases, which lists one
+// relative source path per line.
+func loadLegacyState(r io.Reader) (map[string]stateEntry, error) {
+	state := make(map[string]stateEntry)
 	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
 		if line != "" {
-			state[line] = struct{}{}
+			state[line] = stateEntry{Kind: entryKind(line)}
 		}
 	}
 	return state, scanner.Err()
 }
 
-// saveState writes the relative source paths to the locked state file.
-// It truncates the file before writing and ensures content is synced.
-func saveState(f *os.File, state map[string]struct{}) error {
+// saveState writes the header and the entries, sorted by source path, to the
+// locked state file. It truncates the file before writing and ensures content is synced.
+func saveState(f *os.File, state map[string]stateEntry) error {
 	if err := f.Truncate(0); err != nil {
 		return err
 	}
@@ -94,11 +323,33 @@ func saveState(f *os.File, state map[string]struct{}) error {
 	}
 	sort.Strings(keys)
 
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(stateHeader{Format: stateFormat, Version: stateVersion}); err != nil {
+		return err
+	}
 	for _, srcPath := range keys {
-		if _, err := fmt.Fprintf(f, "%s\n", srcPath); err != nil {
+		entry := state[srcPath]
+		rec := stateRecord{
+			Src:     srcPath,
+			Kind:    entry.Kind,
+			Dest:    entry.Dest,
+			Digest:  entry.Digest,
+			ModTime: entry.ModTime,
+			Applied: entry.Applied,
+			Keys:    entry.Keys,
+		}
+		if entry.Mode != 0 {
+			rec.Mode = fmt.Sprintf("%04o", entry.Mode.Perm())
+		}
+		if err := enc.Encode(rec); err != nil {
 			return err
 		}
 	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
 	// Flush writes to stable storage
 	return f.Sync()
 }
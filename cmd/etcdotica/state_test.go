@@ -0,0 +1,166 @@
+// Copyright 2025-2026 Stanislav Senotrusov
+//
+// This work is dual-licensed under the Apache License, Version 2.0 and the MIT License.
+// See LICENSE-APACHE and LICENSE-MIT in the top-level directory for details.
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadLegacyState(t *testing.T) {
+	legacy := "bin/tool\n\n  .bashrc.aliases-section  \netc/shells.fish-lines##os=linux\n"
+
+	got, err := loadState(strings.NewReader(legacy))
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+
+	want := map[string]stateEntry{
+		"bin/tool":                        {Kind: kindFile},
+		".bashrc.aliases-section":         {Kind: kindSection},
+		"etc/shells.fish-lines##os=linux": {Kind: kindFragment},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestSaveAndLoadState(t *testing.T) {
+	prior := `"old"`
+	mtime := time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC)
+	state := map[string]stateEntry{
+		"b/file": {Kind: kindFile, Dest: "/dst/b/file", Digest: "sha256:1", Mode: 0640, ModTime: mtime, Applied: mtime.Add(time.Second)},
+		"a/":     {Kind: kindDir, Dest: "/dst/a", Mode: 0755},
+		"x.json.p-json-merge": {
+			Kind: kindFragment,
+			Dest: "/dst/x.json",
+			Keys: []mergedKey{{Path: []string{"a", "b<c>"}, Prior: &prior}, {Path: []string{"d"}}},
+		},
+	}
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "state"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Saving truncates what was there before
+	if _, err := f.WriteString("stale content that is longer than the header line, stale content\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveState(f, state); err != nil {
+		t.Fatalf("saveState: %v", err)
+	}
+
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 4 || lines[0] != `{"format":"etcdotica-state","version":1}` {
+		t.Fatalf("unexpected state file:\n%s", data)
+	}
+	if !strings.HasPrefix(lines[1], `{"src":"a/"`) || !strings.Contains(lines[2], `"mode":"0640"`) || !strings.Contains(lines[3], `"b<c>"`) {
+		t.Errorf("entries not sorted or not encoded as expected:\n%s", data)
+	}
+
+	got, err := loadState(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	if !reflect.DeepEqual(got, state) {
+		t.Errorf("got %+v\nwant %+v", got, state)
+	}
+}
+
+func TestLoadStateErrors(t *testing.T) {
+	header := `{"format":"etcdotica-state","version":1}` + "\n"
+
+	tests := []struct {
+		name  string
+		input string
+		want  error
+	}{
+		{"newer version", `{"format":"etcdotica-state","version":99}` + "\n", errStateVersion},
+		{"truncated header", `{"format":"etcdot`, errStateCorrupt},
+		{"damaged header", "  {x\na\n", errStateCorrupt},
+		{"unknown format", `{"format":"other"}` + "\n", errStateCorrupt},
+		{"invalid mode", header + `{"src":"a","mode":"rwx"}` + "\n", errStateCorrupt},
+		{"truncated record", header + `{"src":`, errStateCorrupt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := loadState(strings.NewReader(tt.input)); !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadLegacyState(t *testing.T) {
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, legacyStateFile), []byte("a\nb.x-section\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]stateEntry{
+		"a":           {Kind: kindFile},
+		"b.x-section": {Kind: kindSection},
+	}
+
+	statePath := filepath.Join(t.TempDir(), "state", "file")
+
+	// Before the first sync, a snapshot reads the legacy file
+	got, err := readStateSnapshot(statePath, src)
+	if err != nil {
+		t.Fatalf("readStateSnapshot: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("snapshot: got %+v, want %+v", got, want)
+	}
+
+	f, err := openAndLockState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	got, err = readLegacyState(f, src)
+	if err != nil {
+		t.Fatalf("readLegacyState: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("migrated: got %+v, want %+v", got, want)
+	}
+
+	if err := saveState(f, got); err != nil {
+		t.Fatal(err)
+	}
+	removeLegacyState(src)
+	if _, err := os.Stat(filepath.Join(src, legacyStateFile)); !os.IsNotExist(err) {
+		t.Errorf("legacy state file not removed: %v", err)
+	}
+
+	// Once the state file has content, there is nothing to migrate
+	if got, err := readLegacyState(f, src); err != nil || got != nil {
+		t.Errorf("second migration: got %+v, %v", got, err)
+	}
+
+	f.Close() // Release the lock for the snapshot
+	got, err = readStateSnapshot(statePath, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("after migration: got %+v, want %+v", got, want)
+	}
+}
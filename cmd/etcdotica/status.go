@@ -0,0 +1,219 @@
+// Copyright 2025-2026 Stanislav Senotrusov
+//
+// This work is dual-licensed under the Apache License, Version 2.0 and the MIT License.
+// See LICENSE-APACHE and LICENSE-MIT in the top-level directory for details.
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+// Status values reported for managed paths.
+const (
+	statusInSync       = "in-sync"
+	statusModified     = "destination-modified"
+	statusNewer        = "destination-newer"
+	statusPermDrift    = "permission-drift"
+	statusOwnerDrift   = "owner-drift"
+	statusMissing      = "missing"
+	statusPendingPrune = "pending-prune"
+	statusSectionDrift = "section-drifted"
+	statusError        = "error"
+)
+
+// statusEntry describes how one managed path compares with its source.
+type statusEntry struct {
+	Mapping     string `json:"mapping,omitempty"`
+	Entry       string `json:"entry"`
+	Destination string `json:"destination"`
+	Status      string `json:"status"`
+	Detail      string `json:"detail,omitempty"`
+}
+
+// collectStatus classifies every entry of a job's state file, and every
+// source path a sync would act on that the state does not track yet, such as
+// new files. It runs the syncer in dry-run mode and maps the actions it would
+// take back to the entries they belong to; entries without actions are in sync.
+// Returns the entries sorted by path and whether errors occurred.
+func collectStatus(j *job) ([]statusEntry, bool) {
+	cfg := j.cfg
+	cfg.DryRun = true
+	cfg.Diff = false
+
+	state, err := readStateSnapshot(j.stateFilePath, cfg.Src)
+	if err != nil {
+		logger.Error("Failed to read state file", "path", j.stateFilePath, "err", err)
+		return nil, true
+	}
+
+	s := newSyncer(cfg, state, make(map[string]fileMeta))
+	hasErrors := s.run()
+
+	// Keep the first action recorded for each entry; it is the most significant one.
+	actions := make(map[string]action)
+	for _, a := range s.actions {
+		if _, ok := actions[a.Entry]; !ok {
+			actions[a.Entry] = a
+		}
+	}
+
+	keys := make([]string, 0, len(state))
+	for k := range state {
+		keys = append(keys, k)
+	}
+	for k := range actions {
+		if _, tracked := state[k]; !tracked {
+			keys = append(keys, k)
+		}
+	}
+	for k := range s.failed {
+		_, tracked := state[k]
+		if _, hasAction := actions[k]; !tracked && !hasAction {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	entries := make([]statusEntry, 0, len(keys))
+	for _, relPath := range keys {
+		e := statusEntry{
+			Mapping:     j.name,
+			Entry:       relPath,
+			Destination: destinationPath(s.cfg, relPath),
+			Status:      statusInSync,
+		}
+
+		a, hasAction := actions[relPath]
+		_, tracked := state[relPath]
+
+		switch {
+		case s.failed[relPath]:
+			e.Status = statusError
+			e.Detail = "see log for details"
+		case tracked && !s.processedFiles[relPath]:
+			e.Status = statusPendingPrune
+			if owner, ok := s.claimed[claimKey(s.cfg, relPath)]; ok && !isDirEntry(relPath) {
+				e.Detail = "destination now produced by " + owner
+			} else if _, ok := s.newState[relPath]; ok && !hasAction {
+				e.Detail = "directory not empty"
+			} else if !hasAction {
+				e.Detail = "destination already removed"
+			}
+		case hasAction:
+			e.Status, e.Detail = classifyAction(a)
+			if !tracked && e.Detail == "" {
+				e.Detail = "not synced yet"
+			}
+		}
+
+		entries = append(entries, e)
+	}
+	return entries, hasErrors
+}
+
+// classifyAction maps a planned action to a status and detail.
+func classifyAction(a action) (string, string) {
+	switch a.Kind {
+	case actionCreate, actionMkdir:
+		return statusMissing, ""
+	case actionUpdate:
+		return statusModified, a.Detail
+	case actionChmod:
+		return statusPermDrift, a.Detail
+	case actionChown:
+		return statusOwnerDrift, a.Detail
+	case actionCollect:
+		return statusNewer, "would be collected"
+	case actionSkipNewer:
+		return statusNewer, "would be skipped"
+	case actionSkipEdited:
+		return statusModified, a.Detail + " edited in target, would be skipped"
+	case actionMergeSection:
+		if _, err := os.Stat(a.Path); err != nil {
+			return statusMissing, a.Detail
+		}
+		return statusSectionDrift, a.Detail
+	case actionMergeFragment:
+		if _, err := os.Stat(a.Path); err != nil {
+			return statusMissing, a.Detail
+		}
+		return statusModified, a.Detail
+	case actionRemoveSection, actionRemoveFragment, actionPrune:
+		return statusPendingPrune, ""
+	default:
+		// Metadata-only differences such as mtime do not affect convergence.
+		return statusInSync, a.Detail
+	}
+}
+
+// printStatusTable writes status entries as an aligned table.
+func printStatusTable(w io.Writer, entries []statusEntry) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tMAPPING\tDESTINATION\tDETAIL")
+	for _, e := range entries {
+		mapping := e.Mapping
+		if mapping == "" {
+			mapping = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", e.Status, mapping, e.Destination, e.Detail)
+	}
+	return tw.Flush()
+}
+
+// printStatusJSON writes status entries as a JSON array.
+func printStatusJSON(w io.Writer, entries []statusEntry) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
+
+// runStatus reports the status of every managed path of the given jobs and exits.
+// The exit status is 0 if everything is in sync, 3 if any path has drifted,
+// and 2 if errors occurred while determining the status.
+func runStatus(jobs []*job, format string) {
+	if format != "table" && format != "json" {
+		logger.Error("Error: unsupported status format", "format", format)
+		os.Exit(1)
+	}
+
+	all := []statusEntry{}
+	hasErrors := false
+
+	for _, j := range jobs {
+		applyUmask(j.cfg.ProcessUmask)
+
+		entries, errs := collectStatus(j)
+		all = append(all, entries...)
+		hasErrors = hasErrors || errs
+	}
+
+	var err error
+	if format == "json" {
+		err = printStatusJSON(os.Stdout, all)
+	} else {
+		err = printStatusTable(os.Stdout, all)
+	}
+	if err != nil {
+		logger.Error("Error writing status", "err", err)
+		os.Exit(1)
+	}
+
+	if hasErrors {
+		os.Exit(2)
+	}
+	for _, e := range all {
+		if e.Status != statusInSync {
+			os.Exit(3)
+		}
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,193 @@
+// Copyright 2025-2026 Stanislav Senotrusov
+//
+// This work is dual-licensed under the Apache License, Version 2.0 and the MIT License.
+// See LICENSE-APACHE and LICENSE-MIT in the top-level directory for details.
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// preservesLink reports whether a source symlink is reproduced as a symlink
+// rather than copied as the content it points to. Only plain files are:
+// sections, merge fragments and templates are merged or rendered into their
+// target from the content the link points to.
+func (s *syncer) preservesLink(relPath string) bool {
+	base := alternateBase(relPath)
+	if sectionFileRx.MatchString(base) || isFragment(base) || isTemplate(base) {
+		return false
+	}
+	if s.cfg.Links {
+		return true
+	}
+	relPath = filepath.ToSlash(relPath)
+	for _, g := range s.cfg.LinkPatterns {
+		if g.matchTree(relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleSymlink reproduces a source symlink at the destination with the same
+// link target. Relative targets are kept as they are, so they resolve relative
+// to the destination. A destination link that was changed later than the source
+// link is collected, skipped or overwritten, like a newer regular file.
+func (s *syncer) handleSymlink(srcPath, relPath string, srcInfo os.FileInfo) error {
+	if ok, err := s.selected(relPath); !ok {
+		return err
+	}
+
+	targetPath := destinationPath(s.cfg, relPath)
+	if err := s.claim(relPath); err != nil {
+		s.keep(relPath)
+		return err
+	}
+
+	linkTarget, err := os.Readlink(srcPath)
+	if err != nil {
+		s.keep(relPath)
+		return err
+	}
+
+	s.processedFiles[relPath] = true
+	s.newState[relPath] = stateEntry{Kind: kindLink}
+
+	dstInfo, err := os.Lstat(targetPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var currentTarget string
+	switch {
+	case err != nil:
+		// Destination missing; create the link below
+	case dstInfo.Mode()&os.ModeSymlink != 0:
+		if currentTarget, err = os.Readlink(targetPath); err != nil {
+			return err
+		}
+		if currentTarget == linkTarget {
+			return nil
+		}
+		if done, err := s.handleNewerLink(relPath, srcPath, targetPath, currentTarget, srcInfo, dstInfo); done || err != nil {
+			return err
+		}
+	case dstInfo.IsDir():
+		return fmt.Errorf("conflict: src is symlink, dst is dir")
+	default:
+		// A file we did not write (e.g. on first provisioning) is only replaced
+		// if it is older than the source link, as with regular files.
+		if _, managed := s.oldState[relPath]; !managed && dstInfo.ModTime().After(srcInfo.ModTime()) && !s.cfg.Force {
+			if s.cfg.DryRun {
+				s.record(actionSkipNewer, relPath, targetPath, "")
+				return nil
+			}
+			logger.Warn("Skipping overwrite: destination is newer (use -force to overwrite)", "dst", targetPath)
+			return nil
+		}
+	}
+
+	if s.cfg.Diff {
+		s.diffLink(targetPath, currentTarget, linkTarget, dstInfo)
+	}
+
+	if s.cfg.DryRun {
+		switch {
+		case dstInfo == nil:
+			s.record(actionCreate, relPath, targetPath, "symlink to "+linkTarget)
+		case currentTarget != "":
+			s.record(actionUpdate, relPath, targetPath, fmt.Sprintf("symlink target %s -> %s", currentTarget, linkTarget))
+		default:
+			s.record(actionUpdate, relPath, targetPath, "replace file with symlink to "+linkTarget)
+		}
+		return nil
+	}
+
+	// A regular file in the way may hold local changes
+	if dstInfo != nil && dstInfo.Mode().IsRegular() {
+		if err := s.backup(targetPath, nil); err != nil {
+			return err
+		}
+	}
+
+	if err := replaceLink(targetPath, linkTarget, srcInfo); err != nil {
+		return err
+	}
+	if dstInfo == nil {
+		s.applyOwner(targetPath, false)
+	}
+	logger.Debug("Synced symlink", "path", targetPath, "target", linkTarget)
+	s.changed = true
+	s.markApplied(relPath)
+	s.touched(targetPath)
+	return nil
+}
+
+// handleNewerLink handles a destination link whose target differs and that
+// was changed after the source link. It returns true if the link was collected
+// or skipped, and false if the source link should be applied.
+func (s *syncer) handleNewerLink(relPath, srcPath, dstPath, dstTarget string, srcInfo, dstInfo os.FileInfo) (bool, error) {
+	if !dstInfo.ModTime().After(srcInfo.ModTime()) || s.cfg.Force || s.takesOver(relPath) {
+		return false, nil
+	}
+
+	if s.cfg.Collect {
+		if s.cfg.DryRun {
+			s.record(actionCollect, relPath, dstPath, "symlink to "+dstTarget)
+			return true, nil
+		}
+		logger.Info("Collecting changed symlink from destination", "dst", dstPath, "src", srcPath, "target", dstTarget)
+		if err := replaceLink(srcPath, dstTarget, dstInfo); err != nil {
+			return true, fmt.Errorf("collection failed: %v", err)
+		}
+		return true, nil
+	}
+
+	if s.cfg.DryRun {
+		s.record(actionSkipNewer, relPath, dstPath, "")
+		return true, nil
+	}
+	logger.Warn("Skipping overwrite: destination is newer (use -force to overwrite)", "dst", dstPath)
+	return true, nil
+}
+
+// replaceLink replaces path with a symlink to target and gives the link the
+// modification time of info, so later changes to either side can be told apart.
+func replaceLink(path, target string, info os.FileInfo) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Symlink(target, path); err != nil {
+		return err
+	}
+	if err := setLinkTime(path, info.ModTime()); err != nil {
+		logger.Warn("Failed to set symlink mtime", "path", path, "err", err)
+	}
+	return nil
+}
+
+// diffLink prints a link target change in the form git uses for symlinks:
+// the target is the content, without a trailing newline.
+func (s *syncer) diffLink(dstPath, oldTarget, newTarget string, dstInfo os.FileInfo) {
+	oldLabel := dstPath
+	var oldContent []byte
+	switch {
+	case dstInfo == nil:
+		oldLabel = devNull
+	case oldTarget != "":
+		oldContent = []byte(oldTarget)
+	default:
+		content, _, err := readForDiff(dstPath)
+		if err != nil {
+			logger.Warn("Failed to read destination for diff", "path", dstPath, "err", err)
+			return
+		}
+		oldContent = content
+	}
+	writeDiff(os.Stdout, oldLabel, dstPath, oldContent, []byte(newTarget), 0, 0)
+}
@@ -8,30 +8,216 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
 )
 
 // syncer holds the context for a synchronization operation.
 type syncer struct {
 	cfg            Config
-	oldState       map[string]struct{}
+	oldState       map[string]stateEntry
 	metaCache      map[string]fileMeta
-	newState       map[string]struct{}
+	newState       map[string]stateEntry
 	processedFiles map[string]bool
+	ignore         ignoreMatcher
 	changed        bool
-	hasErrors      bool // Tracks if any file-scoped errors occurred during the run
+	hasErrors      bool              // Tracks if any file-scoped errors occurred during the run
+	failed         map[string]bool   // State entries that hit a file-scoped error
+	changedTargets []string          // Destination paths changed during the run, for hooks
+	applied        map[string]bool   // State entries whose destination was written during the run
+	mergedTargets  map[string]bool   // Target files changed by section merges during the run
+	actions        []action          // Intended changes recorded in dry-run mode
+	pruned         map[string]bool   // Destination paths removed (or planned to be removed) by prune
+	claimed        map[string]string // Destinations produced during the run (see claimKey), mapped to their source entry
+	alternates     map[string]string // Selected alternate for each base path that has alternates ("" if none)
+	host           *hostFacts        // Facts of the running machine, determined on first use
+
+	// Template data, loaded on first use
+	tmplLoaded   bool
+	tmplData     *templateData
+	tmplDataTime time.Time
+	tmplErr      error
 }
 
-func newSyncer(cfg Config, oldState map[string]struct{}, metaCache map[string]fileMeta) *syncer {
+func newSyncer(cfg Config, oldState map[string]stateEntry, metaCache map[string]fileMeta) *syncer {
 	return &syncer{
 		cfg:            cfg,
 		oldState:       oldState,
 		metaCache:      metaCache,
-		newState:       make(map[string]struct{}),
+		newState:       make(map[string]stateEntry),
 		processedFiles: make(map[string]bool),
+		failed:         make(map[string]bool),
+		applied:        make(map[string]bool),
+		pruned:         make(map[string]bool),
+		mergedTargets:  make(map[string]bool),
+		claimed:        make(map[string]string),
+		alternates:     make(map[string]string),
+	}
+}
+
+// fail records a file-scoped error for the given state entry.
+func (s *syncer) fail(relPath string) {
+	s.hasErrors = true
+	s.failed[relPath] = true
+}
+
+// markApplied records that the destination of a state entry was written
+// during the run, so that saveState records its new metadata.
+func (s *syncer) markApplied(relPath string) {
+	s.applied[relPath] = true
+}
+
+// stampState fills in the kind, destination and metadata of the new state
+// entries. Entries applied during the run, and those without metadata (e.g.
+// read from a state file of an earlier release), describe their destination
+// as it is now; the others keep what was recorded when they were last applied.
+func (s *syncer) stampState(now time.Time) {
+	for relPath, entry := range s.newState {
+		old := s.oldState[relPath]
+		if entry.Kind == "" {
+			entry.Kind = entryKind(relPath)
+		}
+		entry.Dest = destinationPath(s.cfg, relPath)
+
+		if s.applied[relPath] || old.ModTime.IsZero() {
+			describeDestination(&entry)
+			entry.Applied = old.Applied
+			if s.applied[relPath] {
+				entry.Applied = now
+			}
+		} else {
+			entry.Mode, entry.ModTime, entry.Applied = old.Mode, old.ModTime, old.Applied
+			if entry.Kind == kindFile {
+				entry.Digest = old.Digest
+			}
+		}
+		s.newState[relPath] = entry
+	}
+}
+
+// describeDestination records the mode and modification time of the
+// destination of an entry and, for files, the digest of its content.
+// A destination that cannot be read is left undescribed.
+func describeDestination(entry *stateEntry) {
+	entry.Mode, entry.ModTime = 0, time.Time{}
+	if entry.Kind == kindFile {
+		entry.Digest = ""
+	}
+
+	info, err := os.Lstat(entry.Dest)
+	if err != nil {
+		return
+	}
+	entry.Mode, entry.ModTime = info.Mode().Perm(), info.ModTime()
+	if entry.Kind == kindFile && info.Mode().IsRegular() {
+		if digest, err := fileDigest(entry.Dest); err == nil {
+			entry.Digest = digest
+		}
+	}
+}
+
+// dirEntry returns the state entry of a directory created by the syncer.
+// The trailing slash distinguishes directories from files.
+func dirEntry(relPath string) string {
+	return relPath + "/"
+}
+
+// isDirEntry reports whether a state entry is a directory.
+func isDirEntry(relPath string) bool {
+	return strings.HasSuffix(relPath, "/")
+}
+
+// destinationPath returns the destination path managed by a state entry.
+// For section files and merge fragments this is the file they are merged
+// into, and for templates and alternates it is the file named without their suffix.
+func destinationPath(cfg Config, relPath string) string {
+	if isDirEntry(relPath) {
+		return filepath.Join(cfg.Dst, relPath)
+	}
+	relPath = alternateBase(relPath)
+	if match := sectionFileRx.FindStringSubmatch(relPath); match != nil {
+		return filepath.Join(cfg.Dst, match[1])
+	}
+	if _, target, _, ok := matchFragment(relPath); ok {
+		return filepath.Join(cfg.Dst, target)
+	}
+	if isTemplate(relPath) {
+		return filepath.Join(cfg.Dst, strings.TrimSuffix(relPath, templateSuffix))
+	}
+	return filepath.Join(cfg.Dst, relPath)
+}
+
+// claimKey identifies what a state entry produces at the destination:
+// a whole file, a single section of a file, or a fragment merged into a file.
+func claimKey(cfg Config, relPath string) string {
+	target := destinationPath(cfg, relPath)
+	if match := sectionFileRx.FindStringSubmatch(alternateBase(relPath)); match != nil {
+		return target + "\x00" + match[2]
+	}
+	if format, _, name, ok := matchFragment(alternateBase(relPath)); ok {
+		return target + "\x00" + format.Name + ":" + name
+	}
+	return target
+}
+
+// claim registers relPath as the producer of its destination for this run.
+// Two sources (e.g. "foo" and "foo.tmpl") must not fight over one destination.
+func (s *syncer) claim(relPath string) error {
+	key := claimKey(s.cfg, relPath)
+	if owner, ok := s.claimed[key]; ok {
+		return fmt.Errorf("conflict: destination %s is already produced by %s", destinationPath(s.cfg, relPath), owner)
+	}
+	s.claimed[key] = relPath
+	return nil
+}
+
+// takesOver reports whether relPath is a new entry whose destination was
+// produced by another entry of the previous run, such as a newly selected
+// alternate or a file renamed to a template. Its destination reflects our own
+// earlier write rather than a local edit.
+func (s *syncer) takesOver(relPath string) bool {
+	if _, ok := s.oldState[relPath]; ok {
+		return false
+	}
+	key := claimKey(s.cfg, relPath)
+	for oldRelPath := range s.oldState {
+		if claimKey(s.cfg, oldRelPath) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// keep marks an entry as processed without syncing it, so that its
+// destination is neither updated nor pruned.
+func (s *syncer) keep(relPath string) {
+	if _, ok := s.oldState[relPath]; ok {
+		s.newState[relPath] = s.oldState[relPath]
+	}
+	s.processedFiles[relPath] = true
+}
+
+// keepTree keeps the state entries of a source directory and of everything
+// below it, for a directory that is skipped because of an error, so that
+// their destinations are not pruned. The entries are reported as failed.
+func (s *syncer) keepTree(relPath string) {
+	prefix := relPath + string(filepath.Separator)
+	if relPath == "." {
+		prefix = ""
+	}
+	s.hasErrors = true
+	for oldRelPath := range s.oldState {
+		if strings.HasPrefix(oldRelPath, prefix) || oldRelPath == dirEntry(relPath) {
+			s.keep(oldRelPath)
+			s.fail(oldRelPath)
+		}
 	}
 }
 
@@ -45,6 +231,7 @@ func (s *syncer) run() bool {
 		s.hasErrors = true
 	}
 	s.prune()
+	s.runHooks()
 	return s.hasErrors
 }
 
@@ -64,7 +251,8 @@ func (s *syncer) visit(path string, info os.FileInfo, err error) error {
 		return nil
 	}
 
-	if relPath == ".etcdotica" {
+	// A state file of an earlier release that could not be migrated away
+	if relPath == legacyStateFile {
 		return nil
 	}
 
@@ -72,6 +260,43 @@ func (s *syncer) visit(path string, info os.FileInfo, err error) error {
 		return filepath.SkipDir
 	}
 
+	if info.Name() == ignoreFileName {
+		return nil
+	}
+
+	// Ignored paths are treated as unmanaged: they are neither synced nor
+	// marked as processed, so previously synced ones are pruned.
+	if relPath != "." && s.ignore.ignored(relPath, info.IsDir()) {
+		logger.Debug("Ignoring source path", "path", relPath)
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	}
+
+	if info.IsDir() {
+		// Without its ignore rules, the directory could sync files that were
+		// meant to stay in the source, such as secrets
+		if err := s.ignore.load(path, relPath, s.cfg.GitIgnore); err != nil {
+			logger.Error("Skipping source directory: failed to read ignore file", "dir", relPath, "err", err)
+			s.keepTree(relPath)
+			return filepath.SkipDir
+		}
+		if err := s.resolveAlternates(path, relPath); err != nil {
+			logger.Error("Failed to select alternate files", "dir", relPath, "err", err)
+			s.hasErrors = true
+		}
+	}
+
+	// Preserved symlinks are reproduced as links, whatever they point to
+	if info.Mode()&os.ModeSymlink != 0 && s.preservesLink(relPath) {
+		if err := s.handleSymlink(path, relPath, info); err != nil {
+			logger.Error("Failed to sync symlink", "path", relPath, "err", err)
+			s.fail(relPath)
+		}
+		return nil
+	}
+
 	// Resolve Symlinks
 	// filepath.Walk uses Lstat (gets link info). We must use Stat (follow link)
 	// to get the actual file info for correct mtime comparison and permission copying.
@@ -80,7 +305,7 @@ func (s *syncer) visit(path string, info os.FileInfo, err error) error {
 		logger.Warn("Skipping unreadable file or broken link", "path", relPath, "err", err)
 		// Mark processed to prevent pruning on read error
 		s.processedFiles[relPath] = true
-		s.hasErrors = true
+		s.fail(relPath)
 		return nil
 	}
 
@@ -91,32 +316,168 @@ func (s *syncer) visit(path string, info os.FileInfo, err error) error {
 	// We treat errors in individual files as partial errors; we do not abort the walk.
 	if err := s.handleFile(path, relPath, realInfo); err != nil {
 		logger.Error("Failed to sync file", "path", relPath, "err", err)
-		s.hasErrors = true
+		s.fail(relPath)
 	}
 	return nil
 }
 
 // handleDirectory creates the directory at the destination.
+// Directories it creates are recorded in the state, so they can be pruned
+// once they are removed from the source.
 func (s *syncer) handleDirectory(relPath string, info os.FileInfo) error {
 	targetPath := filepath.Join(s.cfg.Dst, relPath)
 	expectedPerms := calculatePerms(info.Mode(), s.cfg.ProcessUmask, s.cfg.Everyone)
 
+	entry := dirEntry(relPath)
+	isRoot := relPath == "."
+	if !isRoot {
+		s.processedFiles[entry] = true
+	}
+
+	dstInfo, statErr := os.Stat(targetPath)
+	created := os.IsNotExist(statErr)
+
+	// Existing directories get their mode and owner enforced, except for the
+	// destination root and directories opted out with keep-dir-mode.
+	if statErr == nil && dstInfo.IsDir() && !isRoot && s.enforcesDirMode(relPath) {
+		s.enforceDirMode(entry, targetPath, dstInfo.Mode(), expectedPerms)
+		s.enforceDirOwner(entry, targetPath)
+	}
+
+	// In dry-run mode we only note directories that would be created and keep
+	// walking, so the files inside them are reported as well.
+	if s.cfg.DryRun {
+		if created {
+			s.record(actionMkdir, entry, targetPath, fmt.Sprintf("mode %04o", expectedPerms))
+		}
+		return nil
+	}
+
 	// MkdirAll will create the directory and any necessary parents.
-	// Note that we do not prune directories or modify permissions on existing ones.
+	// Note that we do not prune directories we did not create.
 	if err := os.MkdirAll(targetPath, expectedPerms); err != nil {
 		logger.Warn("Skipping source directory: failed to create", "path", targetPath, "err", err)
 		s.hasErrors = true
 		return filepath.SkipDir // Cannot walk into a directory we failed to create
 	}
+
+	// Directories we create get their owner here, existing ones above
+	if created {
+		s.applyOwner(targetPath, true)
+		s.markApplied(entry)
+	}
+
+	// Pre-existing directories are never tracked, so they are never pruned
+	if _, tracked := s.oldState[entry]; !isRoot && (created || tracked) {
+		s.newState[entry] = stateEntry{}
+		s.changed = s.changed || created
+	}
 	return nil
 }
 
-// handleFile delegates to section handling or regular file handling.
+// enforcesDirMode reports whether the mode of an existing destination
+// directory is enforced, which it is unless the directory matches
+// keep-dir-mode. relPath is relative to the source and destination roots.
+func (s *syncer) enforcesDirMode(relPath string) bool {
+	relPath = filepath.ToSlash(relPath)
+	for _, g := range s.cfg.KeepDirModes {
+		if g.match(relPath, true) {
+			return false
+		}
+	}
+	return true
+}
+
+// enforceDirMode restores the permission bits of an existing destination
+// directory. The setuid, setgid and sticky bits are kept as they are.
+// Drift is logged as a warning; in dry-run mode it is only recorded.
+func (s *syncer) enforceDirMode(entry, dirPath string, current, expected os.FileMode) {
+	if current.Perm() == expected {
+		return
+	}
+	if s.cfg.DryRun {
+		s.record(actionChmod, entry, dirPath, fmt.Sprintf("%04o -> %04o", current.Perm(), expected))
+		return
+	}
+
+	logger.Warn("Directory permissions drifted; restoring", "path", dirPath, "mode", fmt.Sprintf("%04o", current.Perm()), "expected", fmt.Sprintf("%04o", expected))
+	special := current & (os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+	if err := os.Chmod(dirPath, expected|special); err != nil {
+		logger.Error("Failed to restore directory permissions", "path", dirPath, "err", err)
+		s.fail(entry)
+		return
+	}
+	s.markApplied(entry)
+	s.touched(dirPath)
+}
+
+// enforceDirOwner restores the ownership of an existing destination
+// directory, like needsUpdate does for files. Drift is logged as a warning;
+// in dry-run mode it is only recorded.
+func (s *syncer) enforceDirOwner(entry, dirPath string) {
+	expected, err := s.ownerFor(dirPath, true)
+	if err != nil {
+		logger.Error("Failed to determine directory owner", "path", dirPath, "err", err)
+		s.fail(entry)
+		return
+	}
+	if !expected.set() {
+		return
+	}
+	current, err := fileOwnerOf(dirPath)
+	if err != nil {
+		logger.Error("Failed to read directory owner", "path", dirPath, "err", err)
+		s.fail(entry)
+		return
+	}
+	if !expected.differs(current) {
+		return
+	}
+	if s.cfg.DryRun {
+		s.record(actionChown, entry, dirPath, fmt.Sprintf("%s -> %s", current, expected))
+		return
+	}
+
+	logger.Warn("Directory owner drifted; restoring", "path", dirPath, "owner", current.String(), "expected", expected.String())
+	if err := os.Lchown(dirPath, expected.uid, expected.gid); err != nil {
+		logger.Error("Failed to restore directory owner", "path", dirPath, "err", err)
+		s.fail(entry)
+		return
+	}
+	s.markApplied(entry)
+	s.touched(dirPath)
+}
+
+// selected reports whether a source file is synced on this host, which is
+// false for alternates that were not selected.
+func (s *syncer) selected(relPath string) (bool, error) {
+	baseRel := alternateBase(relPath)
+	if winner, ok := s.alternates[baseRel]; ok && winner != relPath {
+		logger.Debug("Skipping alternate not selected for this host", "path", relPath, "selected", winner)
+		return false, nil
+	} else if !ok && baseRel != relPath {
+		// Selection failed for the whole directory; leave the destination alone
+		s.keep(relPath)
+		return false, fmt.Errorf("alternate could not be evaluated")
+	}
+	return true, nil
+}
+
+// handleFile skips alternates not selected for this host and delegates to
+// section handling, fragment merging or regular file handling.
 func (s *syncer) handleFile(srcPath, relPath string, info os.FileInfo) error {
+	if ok, err := s.selected(relPath); !ok {
+		return err
+	}
+	baseRel := alternateBase(relPath)
+
 	// Check for section file
-	if match := sectionFileRx.FindStringSubmatch(relPath); match != nil {
+	if match := sectionFileRx.FindStringSubmatch(baseRel); match != nil {
 		return s.processSection(srcPath, relPath, match[1], match[2], info)
 	}
+	if format, target, name, ok := matchFragment(baseRel); ok {
+		return s.processFragment(format, srcPath, relPath, target, name, info)
+	}
 	return s.processRegularFile(srcPath, relPath, info)
 }
 
@@ -124,19 +485,68 @@ func (s *syncer) handleFile(srcPath, relPath string, info os.FileInfo) error {
 func (s *syncer) processSection(srcPath, relPath, targetRel, sectionName string, info os.FileInfo) error {
 	targetAbsPath := filepath.Join(s.cfg.Dst, targetRel)
 
+	if err := s.claim(relPath); err != nil {
+		s.keep(relPath)
+		return err
+	}
+
 	// We treat the section source file as "processed" so it is not pruned,
 	// but we do NOT copy it as a file to the destination.
-	s.newState[relPath] = struct{}{}
+	s.newState[relPath] = s.oldState[relPath]
 	s.processedFiles[relPath] = true
 
-	// Watch optimization: skip if source hasn't changed
-	if s.checkCache(srcPath, info) {
+	// Watch optimization: skip if neither the source nor the target has changed.
+	// As with regular files, Collect mode checks the target every cycle.
+	srcCached := s.checkCache(srcPath, info)
+	dstCached := s.checkTargetCache(targetAbsPath, sectionName)
+	if !s.cfg.Collect && srcCached && dstCached {
+		return nil
+	}
+
+	style := s.sectionStyle(relPath)
+	place := s.sectionPlacement(relPath)
+	logger.Debug("Processing section", "name", sectionName, "target", targetAbsPath, "comment", style.Name, "placement", place.Spec)
+
+	if s.cfg.Collect {
+		if done, err := s.collectSection(relPath, srcPath, targetAbsPath, sectionName, style, info); err != nil {
+			logger.Error("Failed to collect section", "section", sectionName, "target", targetAbsPath, "err", err)
+			delete(s.metaCache, srcPath)
+			s.fail(relPath)
+			return nil
+		} else if done {
+			return nil
+		}
+	}
+
+	proceed, edited := s.guardEditedSection(relPath, srcPath, targetAbsPath, sectionName, style, place)
+	if !proceed {
 		return nil
 	}
 
-	logger.Debug("Processing section", "name", sectionName, "target", targetAbsPath)
+	if s.cfg.Diff {
+		s.diffSection(srcPath, targetAbsPath, sectionName, style, place, info)
+	}
 
-	didChange, err := mergeSection(srcPath, targetAbsPath, sectionName, info, s.cfg.ProcessUmask, s.cfg.Everyone)
+	if s.cfg.DryRun {
+		if err := s.planSection(relPath, srcPath, targetAbsPath, sectionName, style, place, info); err != nil {
+			logger.Error("Failed to plan section merge", "section", sectionName, "target", targetAbsPath, "err", err)
+			s.fail(relPath)
+		}
+		return nil
+	}
+
+	if !edited {
+		s.reportSectionDrift(relPath, srcPath, targetAbsPath, sectionName, style, place)
+	}
+
+	_, statErr := os.Stat(targetAbsPath)
+	created := os.IsNotExist(statErr)
+
+	didChange, digest, err := mergeSection(srcPath, targetAbsPath, sectionName, style, place, s.cfg.SectionChecksums, info, s.cfg.ProcessUmask, s.cfg.Everyone)
+	if err == nil && created {
+		// The target is usually a shared file, so ownership is only set on files the merge creates
+		s.applyOwner(targetAbsPath, false)
+	}
 
 	if err != nil {
 		logger.Error("Failed to merge section", "section", sectionName, "target", targetAbsPath, "err", err)
@@ -144,37 +554,145 @@ func (s *syncer) processSection(srcPath, relPath, targetRel, sectionName string,
 		// On error, invalidate cache so we retry this file on the next watch cycle
 		delete(s.metaCache, srcPath)
 
-		s.hasErrors = true
-	} else if didChange {
+		s.fail(relPath)
+		return nil
+	}
+
+	if didChange {
 		logger.Debug("Section merged and content changed", "target", targetAbsPath)
+		s.mergedTargets[targetAbsPath] = true
+		s.changed = true
+		s.markApplied(relPath)
+		s.touched(targetAbsPath)
+	}
+	if s.newState[relPath].Digest != digest {
+		s.newState[relPath] = stateEntry{Digest: digest}
 		s.changed = true
 	}
 	return nil
 }
 
-// processRegularFile handles copying or updating standard files.
-func (s *syncer) processRegularFile(srcPath, relPath string, info os.FileInfo) error {
-	targetPath := filepath.Join(s.cfg.Dst, relPath)
+// reportSectionDrift logs a section whose body in the target no longer matches
+// the digest recorded when it was last merged, i.e. that was edited or removed
+// outside of etcdotica, together with the diff that reverts it.
+func (s *syncer) reportSectionDrift(relPath, srcPath, dstPath, sectionName string, style commentStyle, place sectionPlacement) {
+	recorded := s.oldState[relPath].Digest
+	if recorded == "" {
+		return
+	}
+	body, found, err := extractSection(dstPath, sectionName, style)
+	if err != nil || (found && sectionDigest(body) == recorded) {
+		return // Parse errors are reported by the merge
+	}
 
-	// Watch optimization for standard files: skip processing if the source metadata
-	// matches our cache and the file was already successfully recorded in the state.
-	// We disable this optimization if Collect mode is active, as we must check
-	// the destination file's timestamp every cycle to detect newer files to collect.
-	if !s.cfg.Collect && s.checkCache(srcPath, info) {
-		if _, ok := s.oldState[relPath]; ok {
-			s.newState[relPath] = struct{}{}
-			s.processedFiles[relPath] = true
-			return nil
+	var diff bytes.Buffer
+	if oldContent, newContent, err := previewMergeSection(srcPath, dstPath, sectionName, style, place, s.cfg.SectionChecksums); err == nil {
+		writeDiff(&diff, dstPath, dstPath, oldContent, newContent, 0, 0)
+	}
+	change := "edited"
+	if !found {
+		change = "removed"
+	}
+	logger.Warn("Reverting drift of section in target", "section", sectionName, "target", dstPath, "change", change, "diff", diff.String())
+}
+
+// guardEditedSection handles a section whose BEGIN marker carries a checksum
+// that its body in the target no longer matches, i.e. that was edited by hand.
+// The edit is logged together with the diff that would revert it. Unless
+// forced, the section is left alone and reported as an error; otherwise the
+// target is backed up before the edit is overwritten. It returns whether the
+// merge may proceed and whether the section was edited.
+func (s *syncer) guardEditedSection(relPath, srcPath, dstPath, sectionName string, style commentStyle, place sectionPlacement) (bool, bool) {
+	srcLines, err := readLines(srcPath)
+	if err != nil {
+		return true, false // Read errors are reported by the merge
+	}
+	edited, err := sectionEdited(dstPath, sectionName, style, srcLines)
+	if err != nil || !edited {
+		return true, false // Parse errors are reported by the merge
+	}
+
+	if s.cfg.DryRun {
+		if !s.cfg.Force {
+			s.record(actionSkipEdited, relPath, dstPath, "section "+sectionName)
+			return false, true
 		}
+		return true, true
+	}
+
+	var diff bytes.Buffer
+	if oldContent, newContent, err := previewMergeSection(srcPath, dstPath, sectionName, style, place, s.cfg.SectionChecksums); err == nil {
+		writeDiff(&diff, dstPath, dstPath, oldContent, newContent, 0, 0)
+	}
+	logger.Warn("Section was edited in target; its checksum does not match", "section", sectionName, "target", dstPath, "diff", diff.String())
+
+	if !s.cfg.Force {
+		logger.Error("Refusing to overwrite edited section (use -force to overwrite, or -collect to keep the edit)", "section", sectionName, "target", dstPath)
+		s.fail(relPath)
+		return false, true
+	}
+
+	if err := s.backup(dstPath, nil); err != nil {
+		logger.Error("Skipping merge of edited section: backup failed", "section", sectionName, "target", dstPath, "err", err)
+		delete(s.metaCache, srcPath)
+		s.fail(relPath)
+		return false, true
+	}
+	return true, true
+}
+
+// checkTargetCache returns true if the target of a section hasn't changed
+// since the section was last checked (Watch mode). Every section of a target
+// is cached separately, so a change is noticed by all of them.
+func (s *syncer) checkTargetCache(dstPath, sectionName string) bool {
+	if !s.cfg.Watch {
+		return false
+	}
+	info, err := os.Stat(dstPath)
+	if err != nil {
+		delete(s.metaCache, dstPath+"\x00"+sectionName)
+		return false
+	}
+	return s.checkCache(dstPath+"\x00"+sectionName, info)
+}
+
+// processRegularFile handles copying or updating standard files and rendered templates.
+func (s *syncer) processRegularFile(srcPath, relPath string, info os.FileInfo) error {
+	targetPath := destinationPath(s.cfg, relPath)
+
+	if err := s.claim(relPath); err != nil {
+		s.keep(relPath)
+		return err
+	}
+
+	// Decoding a filtered file runs a command, so the watch cache is consulted
+	// before loading. Templates are checked after rendering instead, because
+	// their data file counts as part of the source.
+	tmpl := isTemplate(alternateBase(relPath))
+	if !tmpl && s.cached(srcPath, relPath, info) {
+		return nil
+	}
+
+	src, err := s.loadSource(srcPath, relPath, info)
+	if err != nil {
+		// Keep the entry so a broken template or filter does not prune its destination
+		delete(s.metaCache, srcPath)
+		s.keep(relPath)
+		return err
+	}
+	info = src.info
+
+	if tmpl && s.cached(srcPath, relPath, info) {
+		return nil
 	}
 
 	s.processedFiles[relPath] = true
-	s.newState[relPath] = struct{}{}
+	s.newState[relPath] = stateEntry{}
 
 	// Check if destination is newer than source and handle collect/force logic
-	if done, err := s.handleNewerDestination(srcPath, targetPath, info); err != nil {
+	if done, err := s.handleNewerDestination(relPath, src, targetPath); err != nil {
 		logger.Error("Error checking destination timestamp", "path", targetPath, "err", err)
-		s.hasErrors = true
+		s.fail(relPath)
 		return nil
 	} else if done {
 		// Either collected or skipped due to newer file
@@ -184,31 +702,124 @@ func (s *syncer) processRegularFile(srcPath, relPath string, info os.FileInfo) e
 	// Normal sync path
 	// On error, invalidate cache so we retry this file on the next watch cycle
 	expectedPerms := calculatePerms(info.Mode(), s.cfg.ProcessUmask, s.cfg.Everyone)
-	shouldUpdate, err := s.needsUpdate(targetPath, info, expectedPerms)
+	expectedOwner, err := s.ownerFor(targetPath, false)
+	if err != nil {
+		logger.Error("Error determining destination owner", "path", targetPath, "err", err)
+		delete(s.metaCache, srcPath)
+		s.fail(relPath)
+		return nil
+	}
+	shouldUpdate, err := s.needsUpdate(targetPath, src, expectedPerms, expectedOwner)
 	if err != nil {
 		logger.Error("Error checking destination state", "path", targetPath, "err", err)
 		delete(s.metaCache, srcPath)
-		s.hasErrors = true
+		s.fail(relPath)
+		return nil
+	}
+
+	if shouldUpdate && s.cfg.Diff {
+		s.diffFile(src, targetPath, expectedPerms)
+	}
+
+	if shouldUpdate && s.cfg.DryRun {
+		if err := s.planFile(relPath, src, targetPath, expectedPerms, expectedOwner); err != nil {
+			logger.Error("Failed to plan update", "path", targetPath, "err", err)
+			s.fail(relPath)
+		}
 		return nil
 	}
 
 	if shouldUpdate {
-		if err := syncFile(srcPath, targetPath, info, expectedPerms); err != nil {
+		if err := s.backup(targetPath, &src); err != nil {
+			logger.Error("Skipping update: backup failed", "path", targetPath, "err", err)
+			delete(s.metaCache, srcPath)
+			s.fail(relPath)
+			return nil
+		}
+		if err := syncSource(src, targetPath, expectedPerms, expectedOwner); err != nil {
 			logger.Error("Failed to update/sync", "path", targetPath, "err", err)
 			delete(s.metaCache, srcPath)
-			s.hasErrors = true
+			s.fail(relPath)
 		} else {
 			s.changed = true
+			s.markApplied(relPath)
+			s.touched(targetPath)
 		}
 	}
 
 	return nil
 }
 
+// collectSection copies the body of a section back into its source file if
+// the target file was modified after the source and the body differs from it.
+// Returns (true, nil) if the section was collected, or would be in dry-run mode.
+func (s *syncer) collectSection(relPath, srcPath, dstPath, sectionName string, style commentStyle, srcInfo os.FileInfo) (bool, error) {
+	dstInfo, err := os.Stat(dstPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	// A merge of another section during this run also makes the target newer,
+	// which says nothing about who edited this section.
+	if !dstInfo.Mode().IsRegular() || !dstInfo.ModTime().After(srcInfo.ModTime()) || s.mergedTargets[dstPath] {
+		return false, nil
+	}
+
+	body, found, err := extractSection(dstPath, sectionName, style)
+	if err != nil || !found {
+		return false, err // A removed section is merged again rather than collected as empty
+	}
+	// A body that still matches the digest of the last merge was not edited in
+	// the target, even if other lines of the file were: the source changed.
+	if recorded := s.oldState[relPath].Digest; recorded != "" && sectionDigest(body) == recorded {
+		return false, nil
+	}
+	srcLines, err := readLines(srcPath)
+	if err != nil {
+		return false, err
+	}
+	if slices.Equal(body, srcLines) {
+		return false, nil
+	}
+
+	newContent := []byte(strings.Join(body, "\n"))
+	if len(body) > 0 {
+		newContent = append(newContent, '\n')
+	}
+
+	if s.cfg.Diff {
+		if oldContent, err := os.ReadFile(srcPath); err != nil {
+			logger.Warn("Failed to read source for diff", "path", srcPath, "err", err)
+		} else {
+			writeDiff(os.Stdout, srcPath, srcPath, oldContent, newContent, 0, 0)
+		}
+	}
+
+	if s.cfg.DryRun {
+		s.record(actionCollect, relPath, dstPath, "section "+sectionName)
+		return true, nil
+	}
+
+	logger.Info("Collecting newer section from destination", "dst", dstPath, "section", sectionName, "src", srcPath)
+	// The source gets the target's modification time, so the next run sees both sides in sync
+	info := renderedInfo{FileInfo: srcInfo, size: int64(len(newContent)), modTime: dstInfo.ModTime()}
+	if err := syncContent(bytes.NewReader(newContent), srcPath, info, srcInfo.Mode(), noOwner); err != nil {
+		return true, fmt.Errorf("collection failed: %v", err)
+	}
+	s.metaCache[srcPath] = fileMeta{ModTime: dstInfo.ModTime(), Size: info.size, Mode: srcInfo.Mode()}
+	s.newState[relPath] = stateEntry{Digest: sectionDigest(body)}
+	s.changed = true
+	return true, nil
+}
+
 // handleNewerDestination checks if the target file is newer than the source.
 // Returns (true, nil) if the operation is "done" (either collected or skipped).
 // Returns (false, nil) if the standard sync should proceed (force enabled or dst not newer).
-func (s *syncer) handleNewerDestination(srcPath, dstPath string, srcInfo os.FileInfo) (bool, error) {
+func (s *syncer) handleNewerDestination(relPath string, src sourceFile, dstPath string) (bool, error) {
+	srcPath, srcInfo := src.path, src.info
+
 	// Use os.Stat (not Lstat) so we follow symlinks.
 	// If the destination is a symlink to a file, we want to check the timestamp
 	// of the actual file content, not the link itself.
@@ -227,13 +838,46 @@ func (s *syncer) handleNewerDestination(srcPath, dstPath string, srcInfo os.File
 		return false, nil
 	}
 
+	if dstInfo.ModTime().After(srcInfo.ModTime()) && s.takesOver(relPath) {
+		logger.Debug("Replacing destination produced by another source entry", "dst", dstPath, "src", srcPath)
+		return false, nil
+	}
+
 	if dstInfo.ModTime().After(srcInfo.ModTime()) {
+		if s.cfg.Collect && !src.collectable() {
+			reason := "template"
+			if !src.tmpl {
+				reason = "filter without encode command"
+			}
+			if s.cfg.DryRun {
+				s.record(actionSkipNewer, relPath, dstPath, reason)
+				return true, nil
+			}
+			logger.Warn("Skipping newer destination: it cannot be collected", "dst", dstPath, "src", srcPath, "reason", reason)
+			return true, nil
+		}
+
+		if s.cfg.Collect && s.cfg.Diff {
+			s.diffCollect(src, dstPath)
+		}
+
+		if s.cfg.Collect && s.cfg.DryRun {
+			s.record(actionCollect, relPath, dstPath, "")
+			return true, nil
+		}
+
 		if s.cfg.Collect {
 			logger.Info("Collecting newer file from destination", "dst", dstPath, "src", srcPath)
+			if src.filter != nil {
+				if err := s.collectFiltered(relPath, src, dstPath, dstInfo); err != nil {
+					return true, fmt.Errorf("collection failed: %v", err)
+				}
+				return true, nil
+			}
 			// Reverse sync: Dst becomes Source, Src becomes Dest.
 			// We preserve the Source file's permissions (srcInfo.Mode()) to avoid mode drift in the repo.
 			// syncFile will read from dstPath; since it uses os.Open, it correctly reads the symlink target.
-			if err := syncFile(dstPath, srcPath, dstInfo, srcInfo.Mode()); err != nil {
+			if err := syncFile(dstPath, srcPath, dstInfo, srcInfo.Mode(), noOwner); err != nil {
 				return true, fmt.Errorf("collection failed: %v", err)
 			}
 			// Update meta cache for the source file since we just modified it
@@ -242,6 +886,10 @@ func (s *syncer) handleNewerDestination(srcPath, dstPath string, srcInfo os.File
 		}
 
 		if !s.cfg.Force {
+			if s.cfg.DryRun {
+				s.record(actionSkipNewer, relPath, dstPath, "")
+				return true, nil
+			}
 			logger.Warn("Skipping overwrite: destination is newer (use -force to overwrite)", "dst", dstPath)
 			return true, nil
 		}
@@ -250,6 +898,41 @@ func (s *syncer) handleNewerDestination(srcPath, dstPath string, srcInfo os.File
 	return false, nil
 }
 
+// cached reports whether a source file can be skipped in watch mode: its
+// metadata matches our cache and the file was already successfully recorded
+// in the state. In Collect mode the destination must be unchanged as well, as
+// a newer destination has to be collected.
+func (s *syncer) cached(srcPath, relPath string, info os.FileInfo) bool {
+	// Both sides are checked, so that each cache entry is refreshed
+	srcUnchanged := s.checkCache(srcPath, info)
+	dstUnchanged := !s.cfg.Collect || s.destinationCached(relPath)
+	if !srcUnchanged || !dstUnchanged {
+		return false
+	}
+	if _, ok := s.oldState[relPath]; !ok {
+		return false
+	}
+	s.newState[relPath] = s.oldState[relPath]
+	s.processedFiles[relPath] = true
+	return true
+}
+
+// destinationCached reports whether the destination of a source file is
+// unchanged since the last scan (Watch mode). Both sides share the metadata
+// cache, which is keyed by absolute path.
+func (s *syncer) destinationCached(relPath string) bool {
+	if !s.cfg.Watch {
+		return false
+	}
+	dstPath := destinationPath(s.cfg, relPath)
+	info, err := os.Stat(dstPath)
+	if err != nil {
+		delete(s.metaCache, dstPath)
+		return false
+	}
+	return s.checkCache(dstPath, info)
+}
+
 // checkCache returns true if the file hasn't changed since last scan (Watch mode).
 func (s *syncer) checkCache(path string, info os.FileInfo) bool {
 	if !s.cfg.Watch {
@@ -268,7 +951,9 @@ func (s *syncer) checkCache(path string, info os.FileInfo) bool {
 // needsUpdate checks if the destination file needs to be replaced.
 // It returns true if an update is required, or false if the destination is up to date.
 // It returns an error if the destination state cannot be determined or resolved (e.g. symlink removal failure).
-func (s *syncer) needsUpdate(dstPath string, srcInfo os.FileInfo, expectedPerms os.FileMode) (bool, error) {
+func (s *syncer) needsUpdate(dstPath string, src sourceFile, expectedPerms os.FileMode, expectedOwner fileOwner) (bool, error) {
+	srcInfo := src.info
+
 	// Use Lstat to check destination state so we can detect symlinks
 	dstInfo, err := os.Lstat(dstPath)
 	if err != nil {
@@ -282,6 +967,9 @@ func (s *syncer) needsUpdate(dstPath string, srcInfo os.FileInfo, expectedPerms
 	// - If it links to a file: writing would overwrite the target (bad).
 	// - If it links to a dir: we want to replace it with the source file.
 	if dstInfo.Mode()&os.ModeSymlink != 0 {
+		if s.cfg.DryRun {
+			return true, nil // Reported by planFile; leave the symlink in place
+		}
 		if err := os.Remove(dstPath); err != nil {
 			return false, fmt.Errorf("removing destination symlink: %v", err)
 		}
@@ -295,33 +983,86 @@ func (s *syncer) needsUpdate(dstPath string, srcInfo os.FileInfo, expectedPerms
 	}
 
 	// Check Size, Mtime, Permissions
-	return srcInfo.Size() != dstInfo.Size() ||
+	if srcInfo.Size() != dstInfo.Size() ||
 		!srcInfo.ModTime().Equal(dstInfo.ModTime()) ||
-		dstInfo.Mode().Perm() != expectedPerms, nil
+		dstInfo.Mode().Perm() != expectedPerms {
+		return true, nil
+	}
+
+	// Check Ownership, if managed
+	if expectedOwner.set() {
+		currentOwner, err := fileOwnerOf(dstPath)
+		if err != nil {
+			return false, err
+		}
+		if expectedOwner.differs(currentOwner) {
+			return true, nil
+		}
+	}
+
+	// Rendered output may change without any file changing (e.g. a new
+	// hostname), so templates are also compared by content.
+	if src.content != nil {
+		same, err := src.equalTo(dstPath, dstInfo.Size())
+		return !same, err
+	}
+	return false, nil
 }
 
-// prune removes files or sections that are no longer in the source.
+// prune removes files, sections and created directories that are no longer in the source.
 func (s *syncer) prune() {
+	var dirs []string
+
 	for oldRelPath := range s.oldState {
 		if s.processedFiles[oldRelPath] {
 			continue
 		}
 
-		// Check if it's a section file
-		if match := sectionFileRx.FindStringSubmatch(oldRelPath); match != nil {
-			targetPath := filepath.Join(s.cfg.Dst, match[1])
+		// Directories are removed after the files they may contain
+		if isDirEntry(oldRelPath) {
+			dirs = append(dirs, oldRelPath)
+			continue
+		}
+
+		targetPath := destinationPath(s.cfg, oldRelPath)
 
+		// A destination that another source produced during this run (e.g. after
+		// renaming "foo" to "foo.tmpl", or when another alternate was selected)
+		// is not an orphan.
+		if owner, ok := s.claimed[claimKey(s.cfg, oldRelPath)]; ok {
+			logger.Debug("Orphaned entry's destination is now produced by another source", "entry", oldRelPath, "source", owner)
+			s.changed = true
+			continue
+		}
+
+		// Check if it's a section file
+		if match := sectionFileRx.FindStringSubmatch(alternateBase(oldRelPath)); match != nil {
 			section := match[2]
-			chg, err := removeSection(targetPath, section)
+			style := s.sectionStyle(oldRelPath)
+
+			if s.cfg.Diff {
+				s.diffRemoveSection(targetPath, section, style)
+			}
+
+			if s.cfg.DryRun {
+				if err := s.planRemoveSection(oldRelPath, targetPath, section, style); err != nil {
+					logger.Error("Failed to plan section removal", "section", section, "target", targetPath, "err", err)
+					s.fail(oldRelPath)
+				}
+				continue
+			}
+
+			chg, err := removeSection(targetPath, section, style)
 
 			switch {
 			case err != nil:
 				logger.Error("Failed to remove section", "section", section, "target", targetPath, "err", err)
-				s.hasErrors = true
+				s.fail(oldRelPath)
 
 			case chg:
 				logger.Debug("Removed orphaned section", "section", section, "target", targetPath)
 				s.changed = true
+				s.touched(targetPath)
 
 			default:
 				// This handles the case where err is nil but chg is false
@@ -331,8 +1072,31 @@ func (s *syncer) prune() {
 			continue
 		}
 
+		// Merge fragment: undo the keys it contributed
+		if format, _, name, ok := matchFragment(alternateBase(oldRelPath)); ok {
+			s.pruneFragment(format, oldRelPath, targetPath, name)
+			continue
+		}
+
 		// Regular file
-		targetPath := filepath.Join(s.cfg.Dst, oldRelPath)
+		if s.cfg.Diff {
+			s.diffPrune(targetPath)
+		}
+
+		if s.cfg.DryRun {
+			if err := s.planPrune(oldRelPath, targetPath); err != nil {
+				logger.Error("Failed to plan orphan removal", "file", targetPath, "err", err)
+				s.fail(oldRelPath)
+			}
+			s.pruned[targetPath] = true
+			continue
+		}
+
+		if err := s.backup(targetPath, nil); err != nil {
+			logger.Error("Skipping removal of orphaned file: backup failed", "file", targetPath, "err", err)
+			s.fail(oldRelPath)
+			continue
+		}
 
 		err := os.Remove(targetPath)
 
@@ -340,15 +1104,92 @@ func (s *syncer) prune() {
 		case err == nil:
 			logger.Debug("Removed orphaned file", "file", targetPath)
 			s.changed = true
+			s.pruned[targetPath] = true
+			s.touched(targetPath)
 
 		case errors.Is(err, os.ErrNotExist):
 			logger.Debug("Orphaned file already gone; state matches desired", "file", targetPath)
 			s.changed = true
+			s.pruned[targetPath] = true
 
 		default:
 			logger.Error("Failed to remove orphaned file", "file", targetPath, "err", err)
-			s.hasErrors = true
+			s.fail(oldRelPath)
+		}
+
+	}
+
+	s.pruneDirectories(dirs)
+}
+
+// pruneDirectories removes orphaned directories created by earlier runs,
+// deepest first. A directory that still holds anything besides pruned files
+// is kept, and stays tracked until it is empty.
+func (s *syncer) pruneDirectories(entries []string) {
+	// Reverse order visits "a/b/" before its parent "a/"
+	sort.Sort(sort.Reverse(sort.StringSlice(entries)))
+
+	for _, entry := range entries {
+		targetPath := destinationPath(s.cfg, entry)
+
+		empty, err := s.emptyAfterPrune(targetPath)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			logger.Debug("Orphaned directory already gone; state matches desired", "dir", targetPath)
+			s.changed = true
+			s.pruned[targetPath] = true
+			continue
+		case err != nil:
+			logger.Error("Failed to read orphaned directory", "dir", targetPath, "err", err)
+			s.newState[entry] = stateEntry{}
+			s.fail(entry)
+			continue
+		case !empty:
+			logger.Debug("Keeping orphaned directory until it is empty", "dir", targetPath)
+			s.newState[entry] = stateEntry{}
+			continue
+		}
+
+		if s.cfg.DryRun {
+			s.record(actionPrune, entry, targetPath, "directory")
+			s.pruned[targetPath] = true
+			continue
 		}
 
+		if err := os.Remove(targetPath); err != nil {
+			// Something may have been created in the meantime; try again next time
+			logger.Error("Failed to remove orphaned directory", "dir", targetPath, "err", err)
+			s.newState[entry] = stateEntry{}
+			s.fail(entry)
+			continue
+		}
+		logger.Debug("Removed orphaned directory", "dir", targetPath)
+		s.changed = true
+		s.pruned[targetPath] = true
+		s.touched(targetPath)
+	}
+}
+
+// emptyAfterPrune reports whether a directory contains nothing but paths
+// pruned during this run. A path that is no longer a directory is reported
+// as missing, as it is not ours anymore.
+func (s *syncer) emptyAfterPrune(dirPath string) (bool, error) {
+	info, err := os.Lstat(dirPath)
+	if err != nil {
+		return false, err
+	}
+	if !info.IsDir() {
+		return false, os.ErrNotExist
+	}
+
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return false, err
+	}
+	for _, e := range entries {
+		if !s.pruned[filepath.Join(dirPath, e.Name())] {
+			return false, nil
+		}
 	}
+	return true, nil
 }
@@ -0,0 +1,214 @@
+// Copyright 2025-2026 Stanislav Senotrusov
+//
+// This work is dual-licensed under the Apache License, Version 2.0 and the MIT License.
+// See LICENSE-APACHE and LICENSE-MIT in the top-level directory for details.
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/user"
+	"runtime"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// templateSuffix marks source files that are rendered before being synced.
+// The suffix is stripped from the destination name.
+const templateSuffix = ".tmpl"
+
+// hostFacts describes the machine and user etcdotica runs as.
+type hostFacts struct {
+	Hostname string
+	Username string
+	HomeDir  string
+	OS       string
+	Arch     string
+}
+
+// templateData is the data passed to templates.
+type templateData struct {
+	hostFacts
+	Vars map[string]any // User-defined variables from the data file
+}
+
+// isTemplate reports whether a source path is a template.
+func isTemplate(relPath string) bool {
+	return strings.HasSuffix(relPath, templateSuffix) && len(relPath) > len(templateSuffix)
+}
+
+// currentHostFacts determines the facts of the running machine and user.
+func currentHostFacts() (hostFacts, error) {
+	facts := hostFacts{OS: runtime.GOOS, Arch: runtime.GOARCH}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return hostFacts{}, fmt.Errorf("determining hostname: %v", err)
+	}
+	facts.Hostname = hostname
+
+	u, err := user.Current()
+	if err != nil {
+		return hostFacts{}, fmt.Errorf("determining current user: %v", err)
+	}
+	facts.Username = u.Username
+	facts.HomeDir = u.HomeDir
+
+	return facts, nil
+}
+
+// hostFacts returns the host facts, determining them once per run.
+func (s *syncer) hostFacts() (hostFacts, error) {
+	if s.host == nil {
+		facts, err := currentHostFacts()
+		if err != nil {
+			return hostFacts{}, err
+		}
+		s.host = &facts
+	}
+	return *s.host, nil
+}
+
+// loadTemplateData reads the user variables of dataFile, if set, and combines
+// them with the host facts. It also returns the modification time of the data
+// file (zero if there is none), which counts as a modification time of every template.
+func loadTemplateData(host hostFacts, dataFile string) (*templateData, time.Time, error) {
+	data := &templateData{hostFacts: host, Vars: map[string]any{}}
+
+	if dataFile == "" {
+		return data, time.Time{}, nil
+	}
+
+	info, err := os.Stat(dataFile)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	content, err := os.ReadFile(dataFile)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	root, err := parseToml(string(content))
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("parsing data file %s: %v", dataFile, err)
+	}
+	data.Vars = tomlToMap(root)
+
+	return data, info.ModTime(), nil
+}
+
+// tomlToMap converts a parsed table into plain maps, so templates can index
+// nested tables with the usual dot syntax.
+func tomlToMap(t *tomlTable) map[string]any {
+	m := make(map[string]any, len(t.keys))
+	for _, key := range t.keys {
+		m[key] = tomlToValue(t.values[key])
+	}
+	return m
+}
+
+func tomlToValue(v any) any {
+	switch v := v.(type) {
+	case *tomlTable:
+		return tomlToMap(v)
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = tomlToValue(item)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// renderTemplate executes the template text read from path with data.
+// Referencing an undefined variable is an error rather than an empty string,
+// so a typo cannot silently produce a broken configuration file.
+func renderTemplate(path string, text []byte, data *templateData) ([]byte, error) {
+	tmpl, err := template.New(path).
+		Option("missingkey=error").
+		Funcs(template.FuncMap{"env": os.Getenv}).
+		Parse(string(text))
+	if err != nil {
+		return nil, fmt.Errorf("parsing template: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("rendering template: %v", err)
+	}
+	// A non-nil result distinguishes empty output from verbatim copies
+	return append([]byte{}, buf.Bytes()...), nil
+}
+
+// renderedInfo describes the rendered output of a template or the decoded
+// content of a filtered file. The size is that of the output. For templates,
+// the modification time is the later of the template's and the data file's,
+// so editing either one triggers an update.
+type renderedInfo struct {
+	os.FileInfo
+	size    int64
+	modTime time.Time
+}
+
+func (r renderedInfo) Size() int64        { return r.size }
+func (r renderedInfo) ModTime() time.Time { return r.modTime }
+
+// loadSource prepares a regular source file for syncing. Filtered files are
+// decoded, and templates are rendered (after decoding, if both apply).
+func (s *syncer) loadSource(srcPath, relPath string, info os.FileInfo) (sourceFile, error) {
+	filter := s.filterFor(relPath)
+	tmpl := isTemplate(alternateBase(relPath))
+	if filter == nil && !tmpl {
+		return sourceFile{path: srcPath, info: info}, nil
+	}
+
+	var content []byte
+	var err error
+	if filter != nil {
+		if content, err = s.decodeSource(srcPath, relPath, filter); err != nil {
+			return sourceFile{}, err
+		}
+	}
+
+	modTime := info.ModTime()
+	if tmpl {
+		// Template data is loaded once per run and shared by all templates.
+		if !s.tmplLoaded {
+			host, err := s.hostFacts()
+			if err != nil {
+				return sourceFile{}, err
+			}
+			s.tmplData, s.tmplDataTime, s.tmplErr = loadTemplateData(host, s.cfg.DataFile)
+			s.tmplLoaded = true
+		}
+		if s.tmplErr != nil {
+			return sourceFile{}, fmt.Errorf("loading template data: %v", s.tmplErr)
+		}
+
+		if content == nil {
+			if content, err = os.ReadFile(srcPath); err != nil {
+				return sourceFile{}, err
+			}
+		}
+		if content, err = renderTemplate(srcPath, content, s.tmplData); err != nil {
+			return sourceFile{}, err
+		}
+		if s.tmplDataTime.After(modTime) {
+			modTime = s.tmplDataTime
+		}
+	}
+
+	return sourceFile{
+		path:    srcPath,
+		info:    renderedInfo{FileInfo: info, size: int64(len(content)), modTime: modTime},
+		content: content,
+		tmpl:    tmpl,
+		filter:  filter,
+	}, nil
+}
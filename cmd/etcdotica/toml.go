@@ -0,0 +1,387 @@
+// Copyright 2025-2026 Stanislav Senotrusov
+//
+// This work is dual-licensed under the Apache License, Version 2.0 and the MIT License.
+// See LICENSE-APACHE and LICENSE-MIT in the top-level directory for details.
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// tomlTable is an ordered TOML table.
+// Values are string, bool, int64, []any or *tomlTable.
+type tomlTable struct {
+	keys    []string
+	values  map[string]any
+	defined bool // Named by a [table] header, not only created implicitly
+}
+
+func newTomlTable() *tomlTable {
+	return &tomlTable{values: make(map[string]any)}
+}
+
+// set adds a key, failing on duplicates as TOML requires.
+func (t *tomlTable) set(key string, value any) error {
+	if _, exists := t.values[key]; exists {
+		return fmt.Errorf("duplicate key %q", key)
+	}
+	t.keys = append(t.keys, key)
+	t.values[key] = value
+	return nil
+}
+
+// table returns the named sub-table, creating it if missing.
+func (t *tomlTable) table(key string) (*tomlTable, error) {
+	if v, exists := t.values[key]; exists {
+		sub, ok := v.(*tomlTable)
+		if !ok {
+			return nil, fmt.Errorf("key %q is already defined as a value", key)
+		}
+		return sub, nil
+	}
+	sub := newTomlTable()
+	t.keys = append(t.keys, key)
+	t.values[key] = sub
+	return sub, nil
+}
+
+// parseToml parses the subset of TOML used by etcdotica configuration files:
+// comments, [table] and [dotted.table] headers, bare or quoted keys, and
+// values that are basic or literal strings, booleans, integers, or
+// (possibly multi-line) arrays of those. Other TOML syntax, such as dotted
+// keys, inline tables, floats or dates, is rejected with an error naming it.
+func parseToml(data string) (*tomlTable, error) {
+	p := &tomlParser{src: data, line: 1}
+	root := newTomlTable()
+	current := root
+
+	for {
+		p.skipBlank()
+		if p.eof() {
+			return root, nil
+		}
+
+		if p.peek() == '[' {
+			p.pos++
+			if !p.eof() && p.peek() == '[' {
+				return nil, p.unsupported("arrays of tables")
+			}
+			path, err := p.parseKeyPath(']')
+			if err != nil {
+				return nil, err
+			}
+			current = root
+			for _, key := range path {
+				if current, err = current.table(key); err != nil {
+					return nil, p.errorf("%v", err)
+				}
+			}
+			if current.defined {
+				return nil, p.errorf("table [%s] is defined twice", strings.Join(path, "."))
+			}
+			current.defined = true
+		} else {
+			key, err := p.parseKey()
+			if err != nil {
+				return nil, err
+			}
+			p.skipSpaces()
+			if !p.eof() && p.peek() == '.' {
+				return nil, p.unsupported("dotted keys")
+			}
+			if p.eof() || p.peek() != '=' {
+				return nil, p.errorf("expected '=' after key %q", key)
+			}
+			p.pos++
+			p.skipSpaces()
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			if err := current.set(key, value); err != nil {
+				return nil, p.errorf("%v", err)
+			}
+		}
+
+		if err := p.expectLineEnd(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// tomlParser is a cursor over the TOML source text.
+type tomlParser struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (p *tomlParser) eof() bool  { return p.pos >= len(p.src) }
+func (p *tomlParser) peek() byte { return p.src[p.pos] }
+
+func (p *tomlParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+// unsupported reports valid TOML syntax that is outside the supported subset.
+func (p *tomlParser) unsupported(what string) error {
+	return p.errorf("%s are not supported (etcdotica reads a subset of TOML)", what)
+}
+
+// skipSpaces skips spaces and tabs on the current line.
+func (p *tomlParser) skipSpaces() {
+	for !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+// skipComment skips a comment up to (but not including) the newline.
+func (p *tomlParser) skipComment() {
+	if !p.eof() && p.peek() == '#' {
+		for !p.eof() && p.peek() != '\n' {
+			p.pos++
+		}
+	}
+}
+
+// skipBlank skips whitespace, newlines and comments.
+func (p *tomlParser) skipBlank() {
+	for !p.eof() {
+		switch p.peek() {
+		case ' ', '\t', '\r':
+			p.pos++
+		case '\n':
+			p.pos++
+			p.line++
+		case '#':
+			p.skipComment()
+		default:
+			return
+		}
+	}
+}
+
+// expectLineEnd requires that only whitespace or a comment follows on the line.
+func (p *tomlParser) expectLineEnd() error {
+	p.skipSpaces()
+	p.skipComment()
+	if p.eof() {
+		return nil
+	}
+	if p.peek() == '\r' {
+		p.pos++
+	}
+	if p.eof() || p.peek() != '\n' {
+		return p.errorf("unexpected text after value")
+	}
+	return nil
+}
+
+// parseKeyPath parses dot-separated keys terminated by the given byte.
+func (p *tomlParser) parseKeyPath(terminator byte) ([]string, error) {
+	var path []string
+	for {
+		p.skipSpaces()
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		path = append(path, key)
+		p.skipSpaces()
+		if p.eof() {
+			return nil, p.errorf("unterminated table header")
+		}
+		switch p.peek() {
+		case '.':
+			p.pos++
+		case terminator:
+			p.pos++
+			return path, nil
+		default:
+			return nil, p.errorf("unexpected character %q in table header", p.peek())
+		}
+	}
+}
+
+// parseKey parses a bare or quoted key.
+func (p *tomlParser) parseKey() (string, error) {
+	if p.eof() {
+		return "", p.errorf("expected key")
+	}
+	switch p.peek() {
+	case '"':
+		return p.parseBasicString()
+	case '\'':
+		return p.parseLiteralString()
+	}
+
+	start := p.pos
+	for !p.eof() {
+		c := p.peek()
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-' {
+			p.pos++
+			continue
+		}
+		break
+	}
+	if start == p.pos {
+		return "", p.errorf("expected key, found %q", p.peek())
+	}
+	return p.src[start:p.pos], nil
+}
+
+// parseValue parses a string, boolean, integer or array.
+func (p *tomlParser) parseValue() (any, error) {
+	if p.eof() {
+		return nil, p.errorf("expected value")
+	}
+	rest := p.src[p.pos:]
+	switch c := p.peek(); {
+	case strings.HasPrefix(rest, `"""`), strings.HasPrefix(rest, "'''"):
+		return nil, p.unsupported("multi-line strings")
+	case c == '"':
+		return p.parseBasicString()
+	case c == '\'':
+		return p.parseLiteralString()
+	case c == '[':
+		return p.parseArray()
+	case c == '{':
+		return nil, p.unsupported("inline tables")
+	case strings.HasPrefix(rest, "true"):
+		p.pos += len("true")
+		return true, nil
+	case strings.HasPrefix(rest, "false"):
+		p.pos += len("false")
+		return false, nil
+	case strings.HasPrefix(strings.TrimLeft(rest, "+-"), "inf"), strings.HasPrefix(strings.TrimLeft(rest, "+-"), "nan"):
+		return nil, p.unsupported("floats, dates and times")
+	case strings.HasPrefix(rest, "0x"), strings.HasPrefix(rest, "0o"), strings.HasPrefix(rest, "0b"):
+		return nil, p.unsupported("hexadecimal, octal and binary integers")
+	case c == '-' || c == '+' || c >= '0' && c <= '9':
+		start := p.pos
+		p.pos++
+		for !p.eof() && (p.peek() >= '0' && p.peek() <= '9' || p.peek() == '_') {
+			p.pos++
+		}
+		if !p.eof() && strings.IndexByte(".eE:-", p.peek()) >= 0 {
+			return nil, p.unsupported("floats, dates and times")
+		}
+		n, err := strconv.ParseInt(strings.ReplaceAll(p.src[start:p.pos], "_", ""), 10, 64)
+		if err != nil {
+			return nil, p.errorf("invalid integer %q", p.src[start:p.pos])
+		}
+		return n, nil
+	default:
+		return nil, p.errorf("unsupported value starting with %q", c)
+	}
+}
+
+// parseArray parses an array; elements may span lines and end with a trailing comma.
+func (p *tomlParser) parseArray() ([]any, error) {
+	p.pos++ // Opening bracket
+	var items []any
+	for {
+		p.skipBlank()
+		if p.eof() {
+			return nil, p.errorf("unterminated array")
+		}
+		if p.peek() == ']' {
+			p.pos++
+			return items, nil
+		}
+		item, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		p.skipBlank()
+		if p.eof() {
+			return nil, p.errorf("unterminated array")
+		}
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ']':
+			p.pos++
+			return items, nil
+		default:
+			return nil, p.errorf("expected ',' or ']' in array")
+		}
+	}
+}
+
+// parseBasicString parses a double-quoted string with escape sequences.
+func (p *tomlParser) parseBasicString() (string, error) {
+	p.pos++ // Opening quote
+	var sb strings.Builder
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.peek()
+		p.pos++
+		switch c {
+		case '"':
+			return sb.String(), nil
+		case '\\':
+			if p.eof() {
+				return "", p.errorf("unterminated string")
+			}
+			esc := p.peek()
+			p.pos++
+			switch esc {
+			case 'b':
+				sb.WriteByte('\b')
+			case 't':
+				sb.WriteByte('\t')
+			case 'n':
+				sb.WriteByte('\n')
+			case 'f':
+				sb.WriteByte('\f')
+			case 'r':
+				sb.WriteByte('\r')
+			case '"', '\\':
+				sb.WriteByte(esc)
+			case 'u', 'U':
+				size := 4
+				if esc == 'U' {
+					size = 8
+				}
+				if p.pos+size > len(p.src) {
+					return "", p.errorf("invalid unicode escape")
+				}
+				r, err := strconv.ParseUint(p.src[p.pos:p.pos+size], 16, 32)
+				if err != nil || !utf8.ValidRune(rune(r)) {
+					return "", p.errorf("invalid unicode escape")
+				}
+				sb.WriteRune(rune(r))
+				p.pos += size
+			default:
+				return "", p.errorf("invalid escape sequence \\%c", esc)
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+}
+
+// parseLiteralString parses a single-quoted string without escapes.
+func (p *tomlParser) parseLiteralString() (string, error) {
+	p.pos++ // Opening quote
+	start := p.pos
+	for !p.eof() && p.peek() != '\'' && p.peek() != '\n' {
+		p.pos++
+	}
+	if p.eof() || p.peek() != '\'' {
+		return "", p.errorf("unterminated string")
+	}
+	s := p.src[start:p.pos]
+	p.pos++
+	return s, nil
+}
@@ -0,0 +1,171 @@
+// Copyright 2025-2026 Stanislav Senotrusov
+//
+// This work is dual-licensed under the Apache License, Version 2.0 and the MIT License.
+// See LICENSE-APACHE and LICENSE-MIT in the top-level directory for details.
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// kv is a key and value of a table in the order they were defined.
+type kv struct {
+	Key   string
+	Value any
+}
+
+// plainToml converts parsed values into comparable ones, with tables as
+// ordered key-value lists.
+func plainToml(v any) any {
+	switch v := v.(type) {
+	case *tomlTable:
+		pairs := []kv{}
+		for _, k := range v.keys {
+			pairs = append(pairs, kv{k, plainToml(v.values[k])})
+		}
+		return pairs
+	case []any:
+		items := []any{}
+		for _, item := range v {
+			items = append(items, plainToml(item))
+		}
+		return items
+	}
+	return v
+}
+
+func TestParseToml(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []kv
+	}{
+		{
+			name:  "empty",
+			input: "",
+			want:  []kv{},
+		},
+		{
+			name:  "comments and blank lines",
+			input: "# comment\n\n  # indented\r\n",
+			want:  []kv{},
+		},
+		{
+			name:  "scalars keep their order",
+			input: "b = 'lit\\eral'\na = \"x\\ty\\u00e9\"\nc = true\nd = false\ne = -1_000\nf = +7\n",
+			want: []kv{
+				{"b", `lit\eral`},
+				{"a", "x\tyé"},
+				{"c", true},
+				{"d", false},
+				{"e", int64(-1000)},
+				{"f", int64(7)},
+			},
+		},
+		{
+			name:  "quoted keys and trailing comments",
+			input: "\"*.age\" = 'age -d' # decrypt\n'lit key' = 1\nbare-key_2 = 2\n",
+			want: []kv{
+				{"*.age", "age -d"},
+				{"lit key", int64(1)},
+				{"bare-key_2", int64(2)},
+			},
+		},
+		{
+			name:  "multi-line arrays with trailing comma",
+			input: "a = [\n  'x', # first\n  \"y\",\n]\nb = []\nc = [1, [true]]\n",
+			want: []kv{
+				{"a", []any{"x", "y"}},
+				{"b", []any{}},
+				{"c", []any{int64(1), []any{true}}},
+			},
+		},
+		{
+			name:  "tables and dotted headers",
+			input: "top = 1\n[home]\nsrc = 'home'\n[home.hooks]\n'*.conf' = 'reload'\n[root]\ndst = '/'\n",
+			want: []kv{
+				{"top", int64(1)},
+				{"home", []kv{
+					{"src", "home"},
+					{"hooks", []kv{{"*.conf", "reload"}}},
+				}},
+				{"root", []kv{{"dst", "/"}}},
+			},
+		},
+		{
+			name:  "header of an implicitly created table",
+			input: "[a.b]\nx = 1\n[a]\ny = 2\n",
+			want: []kv{
+				{"a", []kv{
+					{"b", []kv{{"x", int64(1)}}},
+					{"y", int64(2)},
+				}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseToml(tt.input)
+			if err != nil {
+				t.Fatalf("parseToml: %v", err)
+			}
+			if plain := plainToml(got); !reflect.DeepEqual(plain, tt.want) {
+				t.Errorf("got %#v, want %#v", plain, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTomlErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"duplicate key", "a = 1\na = 2\n", "line 2: duplicate key"},
+		{"key redefined as table", "a = 1\n[a]\n", "already defined as a value"},
+		{"repeated table header", "[a]\nx = 1\n[b]\n[a]\ny = 2\n", "line 4: table [a] is defined twice"},
+		{"repeated dotted table header", "[a.b]\n[a]\n[a.b]\n", "table [a.b] is defined twice"},
+		{"missing equals", "a 1\n", "expected '='"},
+		{"missing value", "a =\n", "unsupported value"},
+		{"text after value", "a = 1 2\n", "unexpected text after value"},
+		{"unterminated string", "a = \"x\n", "unterminated string"},
+		{"unterminated literal string", "a = 'x\n", "unterminated string"},
+		{"invalid escape", `a = "\q"`, "invalid escape"},
+		{"invalid unicode escape", `a = "\u12"`, "invalid unicode escape"},
+		{"unterminated array", "a = [1, 2\n", "unterminated array"},
+		{"missing array comma", "a = [1 2]\n", "expected ',' or ']'"},
+		{"unterminated header", "[a\n", "unexpected character"},
+		{"empty header", "[]\n", "expected key"},
+		{"integer overflow", "a = 99999999999999999999\n", "invalid integer"},
+		{"dotted key", "a.b = 1\n", "dotted keys are not supported"},
+		{"inline table", "a = { b = 1 }\n", "inline tables are not supported"},
+		{"array of tables", "[[a]]\n", "arrays of tables are not supported"},
+		{"float", "a = 1.5\n", "floats, dates and times are not supported"},
+		{"exponent", "a = 1e3\n", "floats, dates and times are not supported"},
+		{"infinity", "a = -inf\n", "floats, dates and times are not supported"},
+		{"date", "a = 2026-01-02\n", "floats, dates and times are not supported"},
+		{"time", "a = 07:32:00\n", "floats, dates and times are not supported"},
+		{"hexadecimal", "a = 0xff\n", "hexadecimal, octal and binary integers are not supported"},
+		{"multi-line string", "a = \"\"\"x\"\"\"\n", "multi-line strings are not supported"},
+		{"multi-line literal string", "a = '''x'''\n", "multi-line strings are not supported"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseToml(tt.input)
+			if err == nil {
+				t.Fatalf("parseToml(%q) succeeded, want error containing %q", tt.input, tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("parseToml(%q) error %q, want it to contain %q", tt.input, err, tt.want)
+			}
+		})
+	}
+}
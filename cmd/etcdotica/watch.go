@@ -0,0 +1,70 @@
+// Copyright 2025-2026 Stanislav Senotrusov
+//
+// This work is dual-licensed under the Apache License, Version 2.0 and the MIT License.
+// See LICENSE-APACHE and LICENSE-MIT in the top-level directory for details.
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// watchDebounce is how long to wait after the first change notification
+// before syncing, so editors that save a file in several steps (write,
+// rename, chmod) trigger a single iteration.
+var watchDebounce = 100 * time.Millisecond
+
+// refresh (re)registers watches for the source trees of all jobs, for the
+// targets of their sections and merge fragments (so drift is reverted promptly) and, for jobs in
+// collect mode, for their managed destination files.
+func (w *changeWatcher) refresh(jobs []*job) error {
+	for _, j := range jobs {
+		if err := w.addTree(j.cfg.Src); err != nil {
+			return err
+		}
+		for relPath := range j.cachedState {
+			if isDirEntry(relPath) {
+				continue
+			}
+			baseRel := alternateBase(relPath)
+			if !j.cfg.Collect && !sectionFileRx.MatchString(baseRel) && !isFragment(baseRel) {
+				continue
+			}
+			if err := w.addFile(destinationPath(j.cfg, relPath)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// wait blocks until a change is reported, the timeout elapses, or ctx is cancelled.
+// It returns false if ctx was cancelled.
+func (w *changeWatcher) wait(ctx context.Context, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	case <-w.changes:
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(watchDebounce):
+	}
+
+	// Coalesce notifications that arrived during the debounce window
+	select {
+	case <-w.changes:
+	default:
+	}
+	return true
+}
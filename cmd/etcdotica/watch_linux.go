@@ -0,0 +1,129 @@
+// Copyright 2025-2026 Stanislav Senotrusov
+//
+// This work is dual-licensed under the Apache License, Version 2.0 and the MIT License.
+// See LICENSE-APACHE and LICENSE-MIT in the top-level directory for details.
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// dirWatchMask covers every change to the entries of a source directory.
+	dirWatchMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MODIFY | unix.IN_ATTRIB |
+		unix.IN_CLOSE_WRITE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO |
+		unix.IN_DELETE_SELF | unix.IN_MOVE_SELF | unix.IN_ONLYDIR
+
+	// fileWatchMask covers changes to a single managed destination file.
+	fileWatchMask = unix.IN_MODIFY | unix.IN_ATTRIB | unix.IN_CLOSE_WRITE |
+		unix.IN_DELETE_SELF | unix.IN_MOVE_SELF
+)
+
+// changeWatcher reports filesystem changes using inotify.
+type changeWatcher struct {
+	fd      int
+	file    *os.File      // Wraps fd so reads integrate with the runtime poller and unblock on close
+	changes chan struct{} // Receives a value (coalesced) whenever relevant events arrive
+}
+
+// newChangeWatcher creates an inotify instance and starts reading its events.
+func newChangeWatcher() (*changeWatcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_NONBLOCK | unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("inotify_init1: %v", err)
+	}
+	w := &changeWatcher{
+		fd:      fd,
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		changes: make(chan struct{}, 1),
+	}
+	go w.readEvents()
+	return w, nil
+}
+
+// addTree watches a source directory and all of its subdirectories.
+// Adding an already watched directory is a no-op for inotify, so this can be
+// called after every iteration to pick up newly created directories.
+// It returns an error only when the watch limit is exhausted.
+func (w *changeWatcher) addTree(root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil // Unreadable entries are reported by the sync itself
+		}
+		if d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if _, err := unix.InotifyAddWatch(w.fd, path, dirWatchMask); err != nil {
+			if errors.Is(err, unix.ENOSPC) {
+				return fmt.Errorf("inotify watch limit reached (see fs.inotify.max_user_watches): %v", err)
+			}
+			logger.Debug("Failed to watch directory", "path", path, "err", err)
+		}
+		return nil
+	})
+}
+
+// addFile watches a single file. Missing files are skipped.
+// It returns an error only when the watch limit is exhausted.
+func (w *changeWatcher) addFile(path string) error {
+	if _, err := unix.InotifyAddWatch(w.fd, path, fileWatchMask); err != nil {
+		if errors.Is(err, unix.ENOSPC) {
+			return fmt.Errorf("inotify watch limit reached (see fs.inotify.max_user_watches): %v", err)
+		}
+		if !errors.Is(err, unix.ENOENT) {
+			logger.Debug("Failed to watch file", "path", path, "err", err)
+		}
+	}
+	return nil
+}
+
+// close releases the inotify instance, which also stops the reader goroutine.
+func (w *changeWatcher) close() {
+	w.file.Close()
+}
+
+// readEvents decodes inotify events and signals relevant ones on w.changes.
+func (w *changeWatcher) readEvents() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return // Closed
+		}
+
+		relevant := false
+		for off := 0; off+unix.SizeofInotifyEvent <= n; {
+			mask := binary.NativeEndian.Uint32(buf[off+4:])
+			nameLen := int(binary.NativeEndian.Uint32(buf[off+12:]))
+			nameStart := off + unix.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[nameStart:min(nameStart+nameLen, n)]), "\x00")
+			off = nameStart + nameLen
+
+			// Watch removal notices and the migration of an earlier state file do not require a sync.
+			if mask&unix.IN_IGNORED != 0 || name == legacyStateFile {
+				continue
+			}
+			relevant = true
+		}
+
+		if relevant {
+			select {
+			case w.changes <- struct{}{}:
+			default: // A notification is already pending
+			}
+		}
+	}
+}
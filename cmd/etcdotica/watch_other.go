@@ -0,0 +1,26 @@
+// Copyright 2025-2026 Stanislav Senotrusov
+//
+// This work is dual-licensed under the Apache License, Version 2.0 and the MIT License.
+// See LICENSE-APACHE and LICENSE-MIT in the top-level directory for details.
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+//go:build !linux
+
+package main
+
+import "errors"
+
+// changeWatcher is not implemented on this platform; watch mode polls instead.
+type changeWatcher struct {
+	changes chan struct{}
+}
+
+// newChangeWatcher always fails on platforms without inotify support.
+func newChangeWatcher() (*changeWatcher, error) {
+	return nil, errors.New("filesystem notifications are not supported on this platform")
+}
+
+func (w *changeWatcher) addTree(_ string) error { return nil }
+func (w *changeWatcher) addFile(_ string) error { return nil }
+func (w *changeWatcher) close()                 {}